@@ -1,28 +1,159 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
-func createSignedString(
+// accessClaims are the claims in an access token. SessionID ties the
+// token to the login it came from, so ending the session ends the token.
+// Role is the user's role when the token was issued.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	SessionID string        `json:"sid"`
+	Role      database.Role `json:"role"`
+}
+
+// Access tokens are issued by Chirpy for its own API; a token from another
+// issuer, or meant for another service, is refused even if the signature
+// checks out.
+const (
+	accessTokenIssuer   = "chirpy-access"
+	accessTokenAudience = "chirpy-api"
+)
+
+func createAccessToken(
 	id int,
-	issuer string,
+	sessionID string,
+	role database.Role,
 	expirationDuration time.Duration,
-	jwtSecret string,
+	keys *keyring,
 ) (string, error) {
 
 	expiresAt := jwt.NewNumericDate(time.Now().Add(expirationDuration))
-	claims := jwt.RegisteredClaims{
-		ExpiresAt: expiresAt,
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: expiresAt,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    accessTokenIssuer,
+			Audience:  jwt.ClaimStrings{accessTokenAudience},
+			Subject:   strconv.Itoa(id),
+		},
+		SessionID: sessionID,
+		Role:      role,
+	}
+
+	return keys.sign(claims)
+}
+
+// Challenge tokens stand for a password that has been checked, while the
+// second factor hasn't yet been
+const (
+	challengeTokenIssuer   = "chirpy-2fa"
+	challengeTokenAudience = "chirpy-login"
+	challengeTokenTTL      = 5 * time.Minute
+)
+
+func createChallengeToken(id int, keys *keyring) (string, error) {
+	return keys.sign(jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTokenTTL)),
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		Issuer:    issuer,
+		Issuer:    challengeTokenIssuer,
+		Audience:  jwt.ClaimStrings{challengeTokenAudience},
 		Subject:   strconv.Itoa(id),
+	})
+}
+
+// parseChallengeToken returns the ID of the user a challenge token was
+// issued to
+func parseChallengeToken(tokenString string, keys *keyring) (int, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := keys.parse(
+		tokenString,
+		&claims,
+		jwt.WithIssuer(challengeTokenIssuer),
+		jwt.WithAudience(challengeTokenAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(claims.Subject)
+}
+
+// authenticate checks the request's access token and that the session it
+// was issued for is still active, and returns who made the request
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	claims := accessClaims{}
+	_, err := cfg.keys.parse(
+		bearerToken(r),
+		&claims,
+		jwt.WithIssuer(accessTokenIssuer),
+		jwt.WithAudience(accessTokenAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return principal{}, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString([]byte(jwtSecret))
-	return ss, err
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return principal{}, err
+	}
+
+	session, err := cfg.userDB.GetSession(claims.SessionID)
+	if err != nil {
+		return principal{}, err
+	}
+	if session.UserID != userID || !session.Active(time.Now()) {
+		return principal{}, errors.New("session has ended")
+	}
+	return principal{
+		UserID:    userID,
+		SessionID: session.ID,
+		Role:      claims.Role,
+	}, nil
+}
+
+// bearerToken returns the token from the Authorization header
+func bearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	return strings.Replace(authHeader, "Bearer ", "", 1)
+}
+
+// clientIP is the address the request came from. Forwarding headers are
+// ignored since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+const (
+	accessTokenTTL  = 1 * time.Hour
+	refreshTokenTTL = 60 * 24 * time.Hour
+	resetTokenTTL   = 1 * time.Hour
+	verifyTokenTTL  = 48 * time.Hour
+)
+
+// makeToken returns a random opaque token, for refresh and password reset
+// tokens. The store keeps only its hash, so it can't be recovered once
+// handed out.
+func makeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -3,14 +3,16 @@ package main
 import (
 	"testing"
 	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
-func Test_createSignedString(t *testing.T) {
+func Test_createAccessToken(t *testing.T) {
 	type args struct {
-		id       int
-		issuer   string
-		duration time.Duration
-		secret   string
+		id        int
+		sessionID string
+		duration  time.Duration
+		secret    string
 	}
 	tests := []struct {
 		name    string
@@ -21,25 +23,30 @@ func Test_createSignedString(t *testing.T) {
 		{
 			name: "Generate a signed string",
 			args: args{
-				id:       1,
-				issuer:   "chirpy-access",
-				duration: 5 * time.Second,
-				secret:   "sausages",
+				id:        1,
+				sessionID: "session",
+				duration:  5 * time.Second,
+				secret:    "sausages",
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := createSignedString(
+			keys, err := newKeyring("", tt.args.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := createAccessToken(
 				tt.args.id,
-				tt.args.issuer,
+				tt.args.sessionID,
+				database.RoleUser,
 				tt.args.duration,
-				tt.args.secret,
+				keys,
 			)
 			t.Logf("signed string is:\n%s", got)
 			if (err != nil) != tt.wantErr {
 				t.Errorf(
-					"createSignedString() error = %v, wantErr %v",
+					"createAccessToken() error = %v, wantErr %v",
 					err,
 					tt.wantErr,
 				)
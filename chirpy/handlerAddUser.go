@@ -2,7 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
 func (cfg *apiConfig) addUser(w http.ResponseWriter, r *http.Request) {
@@ -24,7 +28,26 @@ func (cfg *apiConfig) addUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email")
+		return
+	}
+
 	user, err := cfg.userDB.AddUser(params.Email, params.Password)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not register user: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update db")
+		return
+	}
+
+	if _, err := cfg.sendVerification(user.Id, user.Email); err != nil {
+		// they can ask for another once logged in
+		log.Printf("Could not send verification: %s", err)
+	}
 
 	respondWithJSON(w, http.StatusCreated, user)
 
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+// setUserRole lets an admin change another user's role. The user's
+// sessions are ended, since their access tokens carry the old role.
+func (cfg *apiConfig) setUserRole(w http.ResponseWriter, r *http.Request) {
+
+	userId, err := strconv.Atoi(r.PathValue("ID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "User ID must be a number")
+		return
+	}
+	if userId == requestPrincipal(r).UserID {
+		// an admin demoting themselves could leave nobody to undo it
+		respondWithError(w, http.StatusBadRequest, "Can't change your own role")
+		return
+	}
+
+	type parameters struct {
+		Role database.Role `json:"role"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+		return
+	}
+
+	user, err := cfg.userDB.SetUserRole(userId, params.Role)
+	if errors.Is(err, database.ErrInvalidRole) {
+		respondWithError(w, http.StatusBadRequest, "Unknown role")
+		return
+	}
+	if err != nil {
+		respondWithError(
+			w,
+			http.StatusNotFound,
+			fmt.Sprintf("User ID:%d was not found.", userId),
+		)
+		return
+	}
+
+	if err := cfg.userDB.RevokeSessions(userId); err != nil {
+		log.Printf("Could not end sessions of user %d: %s", userId, err)
+	}
+	log.Printf(
+		"User %d made user %d a %s",
+		requestPrincipal(r).UserID,
+		userId,
+		user.Role,
+	)
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// unlockUser lets an admin clear the failed logins that locked a user's
+// account
+func (cfg *apiConfig) unlockUser(w http.ResponseWriter, r *http.Request) {
+
+	userId, err := strconv.Atoi(r.PathValue("ID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "User ID must be a number")
+		return
+	}
+	user, err := cfg.userDB.GetUser(userId)
+	if err != nil {
+		respondWithError(
+			w,
+			http.StatusNotFound,
+			fmt.Sprintf("User ID:%d was not found.", userId),
+		)
+		return
+	}
+
+	if err := cfg.userDB.ClearLoginFailures(accountKey(user.Email)); err != nil {
+		log.Printf("Could not unlock user %d: %s", userId, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	log.Printf("User %d unlocked user %d", requestPrincipal(r).UserID, userId)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// moderateChirp lets a moderator delete anyone's chirp
+func (cfg *apiConfig) moderateChirp(w http.ResponseWriter, r *http.Request) {
+
+	chirpId, err := strconv.Atoi(r.PathValue("ID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Chirp ID must be a number")
+		return
+	}
+
+	chirp, err := cfg.chirpsDB.GetChirp(chirpId)
+	if err != nil {
+		respondWithError(
+			w,
+			http.StatusNotFound,
+			fmt.Sprintf("Chirp ID:%d was not found.", chirpId),
+		)
+		return
+	}
+
+	if err := cfg.chirpsDB.DeleteChirp(chirpId); err != nil {
+		log.Printf("Could not delete chirp %d: %s", chirpId, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	log.Printf(
+		"Moderator %d deleted chirp %d by user %d",
+		requestPrincipal(r).UserID,
+		chirpId,
+		chirp.AuthorId,
+	)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// bootstrapAdmin makes the registered user with email an admin, so that
+// a new deployment has someone who can hand out roles. It only does so
+// while there is no admin yet, and only once the email has been verified;
+// otherwise anyone could register the address and be promoted on the next
+// restart, and an admin who was demoted would be promoted again.
+func bootstrapAdmin(userDB database.UserStore, email string) error {
+	users, err := userDB.GetUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Role == database.RoleAdmin {
+			log.Printf("User %d (%s) is already an admin", user.Id, user.Email)
+			return nil
+		}
+	}
+
+	id, err := userDB.GetUserId(email)
+	if err != nil {
+		return fmt.Errorf("no user registered as %s", email)
+	}
+	user, err := userDB.GetUser(id)
+	if err != nil {
+		return err
+	}
+	if !user.Verified {
+		return fmt.Errorf("%s has not been verified", email)
+	}
+	user, err = userDB.SetUserRole(id, database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	log.Printf("User %d (%s) is an admin", user.Id, user.Email)
+	return nil
+}
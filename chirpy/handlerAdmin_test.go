@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+// verifyUser marks the email of the user registered with it as verified
+func verifyUser(t *testing.T, cfg *apiConfig, email string) {
+	t.Helper()
+	id, err := cfg.userDB.GetUserId(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := "verify-" + email
+	_, err = cfg.userDB.CreateEmailVerification(id, email, token, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.userDB.VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_adminRoutes(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	chirpsDB, err := database.NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.chirpsDB = chirpsDB
+	if _, err := cfg.userDB.AddUser("jesse@breakingbad.com", "abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	chirp, _ := chirpsDB.StoreChirp("yo", 1)
+
+	if err := bootstrapAdmin(cfg.userDB, "walt@breakingbad.com"); err == nil {
+		t.Error("bootstrapAdmin() promoted an unverified email")
+	}
+	verifyUser(t, cfg, "walt@breakingbad.com")
+	if err := bootstrapAdmin(cfg.userDB, "walt@breakingbad.com"); err != nil {
+		t.Fatalf("bootstrapAdmin() error = %v", err)
+	}
+	if err := bootstrapAdmin(cfg.userDB, "gus@lospolloshermanos.com"); err != nil {
+		t.Errorf("bootstrapAdmin() with an admin already = %v, want it skipped", err)
+	}
+	// once there is an admin nobody else is promoted
+	verifyUser(t, cfg, "jesse@breakingbad.com")
+	if err := bootstrapAdmin(cfg.userDB, "jesse@breakingbad.com"); err != nil {
+		t.Errorf("bootstrapAdmin() with an admin already = %v, want it skipped", err)
+	}
+	walt := login(t, cfg, "laptop")
+	jesse := loginAs(t, cfg, "jesse@breakingbad.com", "abcdef", "phone")
+	if walt.Role != database.RoleAdmin || jesse.Role != database.RoleUser {
+		t.Fatalf("roles at login = %q, %q; want admin, user", walt.Role, jesse.Role)
+	}
+
+	do := func(
+		role database.Role,
+		handler http.HandlerFunc,
+		method, url, body, token string,
+	) int {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		// the mux would set these from the route pattern
+		parts := strings.Split(url, "/")
+		if len(parts) > 3 {
+			req.SetPathValue("ID", parts[3])
+		}
+		rec := httptest.NewRecorder()
+		cfg.middlewareRole(role, handler)(rec, req)
+		return rec.Code
+	}
+	admin := database.RoleAdmin
+	moderator := database.RoleModerator
+
+	if code := do(admin, cfg.getFsHits, "GET", "/admin/metrics", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("metrics without a token = %d, want 401", code)
+	}
+	if code := do(admin, cfg.getFsHits, "GET", "/admin/metrics", "", jesse.AccessToken); code != http.StatusForbidden {
+		t.Errorf("metrics as a user = %d, want 403", code)
+	}
+	if code := do(admin, cfg.getFsHits, "GET", "/admin/metrics", "", walt.AccessToken); code != http.StatusOK {
+		t.Errorf("metrics as an admin = %d, want 200", code)
+	}
+	if code := do(admin, cfg.resetFsHits, "POST", "/api/reset", "", jesse.AccessToken); code != http.StatusForbidden {
+		t.Errorf("reset as a user = %d, want 403", code)
+	}
+
+	deleteURL := "/admin/chirps/1"
+	if code := do(moderator, cfg.moderateChirp, "DELETE", deleteURL, "", jesse.AccessToken); code != http.StatusForbidden {
+		t.Errorf("moderating as a user = %d, want 403", code)
+	}
+
+	roleURL := "/admin/users/2/role"
+	if code := do(admin, cfg.setUserRole, "PUT", roleURL, `{"role":"moderator"}`, jesse.AccessToken); code != http.StatusForbidden {
+		t.Errorf("setUserRole as a user = %d, want 403", code)
+	}
+	if code := do(admin, cfg.setUserRole, "PUT", roleURL, `{"role":"owner"}`, walt.AccessToken); code != http.StatusBadRequest {
+		t.Errorf("setUserRole to an unknown role = %d, want 400", code)
+	}
+	if code := do(admin, cfg.setUserRole, "PUT", "/admin/users/1/role", `{"role":"user"}`, walt.AccessToken); code != http.StatusBadRequest {
+		t.Errorf("admin changing their own role = %d, want 400", code)
+	}
+	if code := do(admin, cfg.setUserRole, "PUT", roleURL, `{"role":"moderator"}`, walt.AccessToken); code != http.StatusOK {
+		t.Fatalf("setUserRole = %d, want 200", code)
+	}
+
+	// jesse's token still says user, so it has been ended with the session
+	if code := do(moderator, cfg.moderateChirp, "DELETE", deleteURL, "", jesse.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("moderating with a token from before the change = %d, want 401", code)
+	}
+	jesse = loginAs(t, cfg, "jesse@breakingbad.com", "abcdef", "phone")
+	if code := do(moderator, cfg.moderateChirp, "DELETE", deleteURL, "", jesse.AccessToken); code != http.StatusOK {
+		t.Errorf("moderating as a moderator = %d, want 200", code)
+	}
+	if _, err := chirpsDB.GetChirp(chirp.Id); err == nil {
+		t.Error("moderateChirp() left the chirp in place")
+	}
+	if code := do(admin, cfg.getFsHits, "GET", "/admin/metrics", "", jesse.AccessToken); code != http.StatusForbidden {
+		t.Errorf("metrics as a moderator = %d, want 403", code)
+	}
+}
@@ -9,17 +9,13 @@ import (
 
 func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
 
-	token, _, err := getTokenAndStringFromHeader(r, cfg.secret)
-	issuer, authorId, err := parseToken(token)
-	if issuer != "chirpy-access" {
-		respondWithError(w, http.StatusUnauthorized, "Bad token")
-		return
-	}
+	authorId := requestPrincipal(r).UserID
 
 	path := r.PathValue("ID")
 	chirpId, err := strconv.Atoi(path)
 	if err != nil {
 		log.Printf("Error: ID %s could not be converted to integer", path)
+		respondWithError(w, http.StatusBadRequest, "Chirp ID must be a number")
 		return
 	}
 
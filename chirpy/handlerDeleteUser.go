@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// chirpPolicy is what happens to a user's chirps when they delete their
+// account: chirpsDelete deletes them, chirpsAnonymize keeps them without
+// an author
+type chirpPolicy string
+
+const (
+	chirpsDelete    chirpPolicy = "delete"
+	chirpsAnonymize chirpPolicy = "anonymize"
+)
+
+func parseChirpPolicy(s string) (chirpPolicy, error) {
+	switch p := chirpPolicy(s); p {
+	case chirpsDelete, chirpsAnonymize:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q; want delete or anonymize", s)
+}
+
+// deleteUser deletes the user's account once they confirm their password.
+// Their chirps go as -deleted-chirps says, their sessions end and their
+// email can be registered again.
+func (cfg *apiConfig) deleteUser(w http.ResponseWriter, r *http.Request) {
+
+	userID := requestPrincipal(r).UserID
+
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+		return
+	}
+
+	user, err := cfg.userDB.GetUser(userID)
+	if err != nil {
+		log.Printf("Could not look up user: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if !cfg.confirmPassword(w, r, user, params.Password) {
+		return
+	}
+
+	// chirps first: if this fails the account is still there to retry with
+	var n int
+	if cfg.deletedChirps == chirpsAnonymize {
+		n, err = cfg.chirpsDB.AnonymizeChirps(userID)
+	} else {
+		n, err = cfg.chirpsDB.DeleteChirpsByAuthor(userID)
+	}
+	if err != nil {
+		log.Printf("Could not remove chirps of user %d: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if err := cfg.userDB.DeleteUser(userID); err != nil {
+		log.Printf("Could not delete user %d: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if err := cfg.userDB.ClearLoginFailures(accountKey(user.Email)); err != nil {
+		log.Printf("Could not clear failed logins: %s", err)
+	}
+	log.Printf("User %d deleted their account; %d chirps %sd", userID, n, cfg.deletedChirps)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_deleteUser(t *testing.T) {
+	for _, policy := range []chirpPolicy{chirpsDelete, chirpsAnonymize} {
+		t.Run(string(policy), func(t *testing.T) {
+			cfg := setupAuthConfig(t)
+			chirpsDB, err := database.NewDB(filepath.Join(t.TempDir(), "storage.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.chirpsDB = chirpsDB
+			cfg.deletedChirps = policy
+			chirpsDB.StoreChirp("say my name", 1)
+			chirpsDB.StoreChirp("yo", 2)
+			walt := login(t, cfg, "laptop")
+
+			del := func(body string) int {
+				t.Helper()
+				req := httptest.NewRequest(http.MethodDelete, "/api/users/me", strings.NewReader(body))
+				req.Header.Set("Authorization", "Bearer "+walt.AccessToken)
+				rec := httptest.NewRecorder()
+				cfg.middlewareAuth(cfg.deleteUser)(rec, req)
+				return rec.Code
+			}
+
+			if code := del(`{"password":"wrong"}`); code != http.StatusUnauthorized {
+				t.Fatalf("deleteUser() with a wrong password = %d, want 401", code)
+			}
+			if code := del(`{"password":"123456"}`); code != http.StatusNoContent {
+				t.Fatalf("deleteUser() = %d, want 204", code)
+			}
+			if _, err := cfg.userDB.GetUser(1); err == nil {
+				t.Error("user still exists")
+			}
+			// the access token's session is gone with the account
+			if code := del(`{"password":"123456"}`); code != http.StatusUnauthorized {
+				t.Errorf("deleteUser() again = %d, want 401", code)
+			}
+
+			chirps, _ := chirpsDB.GetChirps(false)
+			switch policy {
+			case chirpsDelete:
+				if len(chirps) != 1 || chirps[0].AuthorId != 2 {
+					t.Errorf("chirps left = %+v, want only the other user's", chirps)
+				}
+			case chirpsAnonymize:
+				if len(chirps) != 2 || chirps[0].AuthorId != database.AnonymousAuthor {
+					t.Errorf("chirps left = %+v, want the user's without an author", chirps)
+				}
+				// and they can be listed on their own
+				rec := httptest.NewRecorder()
+				cfg.getChirps(rec, httptest.NewRequest(http.MethodGet, "/api/chirps?author_id=-1", nil))
+				anonymous := []database.Chirp{}
+				json.Unmarshal(rec.Body.Bytes(), &anonymous)
+				if rec.Code != http.StatusOK || len(anonymous) != 1 {
+					t.Errorf("listing anonymous chirps = %d, %+v; want the user's one", rec.Code, anonymous)
+				}
+			}
+
+			// the email is free again
+			if _, err := cfg.userDB.AddUser("walt@breakingbad.com", "654321"); err != nil {
+				t.Errorf("registering the deleted user's email error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// exportUser sends the user everything kept about them as a ZIP of JSON
+// files: their profile, all their chirps and their session history
+func (cfg *apiConfig) exportUser(w http.ResponseWriter, r *http.Request) {
+
+	userID := requestPrincipal(r).UserID
+
+	// gather it all first, so a failure can still be reported properly
+	user, err := cfg.userDB.GetUser(userID)
+	if err != nil {
+		log.Printf("Could not look up user: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	chirps, err := cfg.chirpsDB.ChirpsByAuthorID(userID, false)
+	if err != nil {
+		log.Printf("Could not get chirps of user %d: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	sessions, err := cfg.userDB.SessionHistory(userID)
+	if err != nil {
+		log.Printf("Could not get sessions of user %d: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", user},
+		{"chirps.json", chirps},
+		{"sessions.json", sessions},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			log.Printf("Could not export %s for user %d: %s", f.name, userID, err)
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			log.Printf("Could not export %s for user %d: %s", f.name, userID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Could not finish export for user %d: %s", userID, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_exportUser(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	chirpsDB, err := database.NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.chirpsDB = chirpsDB
+	chirpsDB.StoreChirp("say my name", 1)
+	chirpsDB.StoreChirp("yo", 2)
+	// a session that has expired, and whose tokens have been pruned,
+	// is still part of the history
+	cfg.userDB.CreateSession(1, "old phone", "", "old-token", time.Now().Add(-time.Hour))
+	login(t, cfg, "phone")
+	walt := login(t, cfg, "laptop")
+	cfg.userDB.RevokeSessions(1)
+	walt = login(t, cfg, "laptop")
+	cfg.userDB.PruneRefreshTokens()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/me/export", nil)
+	req.Header.Set("Authorization", "Bearer "+walt.AccessToken)
+	rec := httptest.NewRecorder()
+	cfg.middlewareAuth(cfg.exportUser)(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("exportUser() = %d, %q; want a zip", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("export is not a zip: %s", err)
+	}
+	read := func(name string, v any) {
+		t.Helper()
+		f, err := zr.Open(name)
+		if err != nil {
+			t.Fatalf("export has no %s: %s", name, err)
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("could not decode %s: %s", name, err)
+		}
+	}
+
+	profile := database.User{}
+	read("profile.json", &profile)
+	if profile.Id != 1 || profile.Email != "walt@breakingbad.com" {
+		t.Errorf("profile = %+v, want walt", profile)
+	}
+	chirps := []database.Chirp{}
+	read("chirps.json", &chirps)
+	if len(chirps) != 1 || chirps[0].Body != "say my name" {
+		t.Errorf("chirps = %+v, want only walt's", chirps)
+	}
+	sessions := []database.Session{}
+	read("sessions.json", &sessions)
+	if len(sessions) != 4 {
+		t.Errorf("sessions = %d, want all 4 including the ended ones", len(sessions))
+	}
+	expired := false
+	for _, s := range sessions {
+		expired = expired || s.UserAgent == "old phone"
+	}
+	if !expired {
+		t.Errorf("sessions = %+v, want the expired one included", sessions)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
 func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
@@ -12,32 +15,93 @@ func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
 	s := r.URL.Query().Get("author_id")
 	order := r.URL.Query().Get("sort")
 
-	desc := false
-	if order == "desc" {
-		desc = true
+	q := database.ChirpQuery{}
+	switch order {
+	case "desc":
+		q.Desc = true
+	case "created_at":
+		q.SortBy = database.SortByCreatedAt
+	case "-created_at":
+		q.SortBy = database.SortByCreatedAt
+		q.Desc = true
 	}
 
-	if s == "" {
-		cfg.allChirps(w, desc)
+	if s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			respondWithError(
+				w,
+				http.StatusBadRequest,
+				fmt.Sprintf("Invalid author_id: %s", s),
+			)
+			return
+		}
+		q.AuthorID = id
+	}
+
+	var err error
+	if q.Since, err = parseTimeParam(r, "since"); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if q.Until, err = parseTimeParam(r, "until"); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	authorID, err := strconv.Atoi(s)
-	if err != nil {
-		respondWithError(
-			w,
-			http.StatusBadRequest,
-			fmt.Sprintf("Invalid author_id: %s", s),
-		)
+	// clients that ask for a page get one; the rest get the full list
+	if isPageRequest(r) {
+		cfg.chirpsPage(w, r, q)
+		return
+	}
+
+	if q.SortBy != database.SortByID || !q.Since.IsZero() || !q.Until.IsZero() {
+		cfg.queryChirps(w, r, q)
+		return
+	}
+
+	if q.AuthorID == 0 {
+		cfg.allChirps(w, r, q.Desc)
 		return
 	}
 
-	cfg.chirpsByAuthorID(w, authorID, desc)
+	cfg.chirpsByAuthorID(w, r, q.AuthorID, q.Desc)
 	return
 }
 
+// parseTimeParam reads an RFC 3339 time from the query string;
+// a missing parameter gives the zero time
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s: %s", name, s)
+	}
+	return t, nil
+}
+
+// queryChirps answers the full list when it is sorted by time or
+// filtered on it, which the older store methods can't do
+func (cfg *apiConfig) queryChirps(
+	w http.ResponseWriter,
+	r *http.Request,
+	q database.ChirpQuery,
+) {
+	page, err := cfg.chirpsDB.ChirpsPage(q)
+	if err != nil {
+		log.Printf("Could not retrieve chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.chirpResponses(r, page.Chirps))
+}
+
 func (cfg *apiConfig) chirpsByAuthorID(
 	w http.ResponseWriter,
+	r *http.Request,
 	authorID int,
 	desc bool,
 ) {
@@ -53,15 +117,19 @@ func (cfg *apiConfig) chirpsByAuthorID(
 		)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, chirps)
+	respondWithJSON(w, http.StatusOK, cfg.chirpResponses(r, chirps))
 }
 
-func (cfg *apiConfig) allChirps(w http.ResponseWriter, desc bool) {
+func (cfg *apiConfig) allChirps(
+	w http.ResponseWriter,
+	r *http.Request,
+	desc bool,
+) {
 	chirps, err := cfg.chirpsDB.GetChirps(desc)
 	if err != nil {
 		log.Println("Could not retrieve chirps from database")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	respondWithJSON(w, http.StatusOK, cfg.chirpResponses(r, chirps))
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+// fakeChirpStore is an in-memory ChirpStore for handler tests. Methods that
+// a test doesn't need fall through to the nil embedded interface and panic.
+type fakeChirpStore struct {
+	database.ChirpStore
+	chirps []database.Chirp
+	// query is the last one passed to ChirpsPage
+	query database.ChirpQuery
+}
+
+func (f *fakeChirpStore) ChirpsPage(
+	q database.ChirpQuery,
+) (database.ChirpPage, error) {
+	f.query = q
+	return database.ChirpPage{Chirps: f.chirps}, nil
+}
+
+func (f *fakeChirpStore) GetChirps(desc bool) ([]database.Chirp, error) {
+	return f.chirps, nil
+}
+
+func (f *fakeChirpStore) ChirpsByAuthorID(
+	authorId int,
+	desc bool,
+) ([]database.Chirp, error) {
+	chirps := []database.Chirp{}
+	for _, ch := range f.chirps {
+		if ch.AuthorId == authorId {
+			chirps = append(chirps, ch)
+		}
+	}
+	return chirps, nil
+}
+
+func Test_getChirps(t *testing.T) {
+	store := &fakeChirpStore{
+		chirps: []database.Chirp{
+			{Id: 1, Body: "first", AuthorId: 1},
+			{Id: 2, Body: "second", AuthorId: 2},
+			{Id: 3, Body: "third", AuthorId: 1},
+		},
+	}
+	cfg := apiConfig{chirpsDB: store}
+
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		want     []database.Chirp
+	}{
+		{
+			name:     "all chirps",
+			url:      "/api/chirps",
+			wantCode: http.StatusOK,
+			want:     store.chirps,
+		},
+		{
+			name:     "chirps by author",
+			url:      "/api/chirps?author_id=1",
+			wantCode: http.StatusOK,
+			want:     []database.Chirp{store.chirps[0], store.chirps[2]},
+		},
+		{
+			name:     "bad author id",
+			url:      "/api/chirps?author_id=abc",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			cfg.getChirps(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("getChirps() code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.want == nil {
+				return
+			}
+			got := []database.Chirp{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("could not decode response: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getChirps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getChirpsByTime(t *testing.T) {
+	since := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	until := since.Add(24 * time.Hour)
+
+	tests := []struct {
+		name      string
+		url       string
+		wantCode  int
+		wantQuery database.ChirpQuery
+	}{
+		{
+			name:      "sorted by creation time",
+			url:       "/api/chirps?sort=created_at",
+			wantCode:  http.StatusOK,
+			wantQuery: database.ChirpQuery{SortBy: database.SortByCreatedAt},
+		},
+		{
+			name:     "newest first within a range",
+			url:      "/api/chirps?sort=-created_at&author_id=2&since=2024-05-01T12:00:00Z&until=2024-05-02T12:00:00Z",
+			wantCode: http.StatusOK,
+			wantQuery: database.ChirpQuery{
+				AuthorID: 2,
+				SortBy:   database.SortByCreatedAt,
+				Desc:     true,
+				Since:    since,
+				Until:    until,
+			},
+		},
+		{
+			name:      "offset times",
+			url:       "/api/chirps?since=2024-05-01T14:00:00%2B02:00",
+			wantCode:  http.StatusOK,
+			wantQuery: database.ChirpQuery{Since: since},
+		},
+		{
+			name:     "bad since",
+			url:      "/api/chirps?since=yesterday",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "bad until",
+			url:      "/api/chirps?until=2024-05-01",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeChirpStore{chirps: []database.Chirp{}}
+			cfg := apiConfig{chirpsDB: store}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			cfg.getChirps(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("getChirps() code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			got := store.query
+			if !got.Since.Equal(tt.wantQuery.Since) || !got.Until.Equal(tt.wantQuery.Until) {
+				t.Errorf("getChirps() range = %v..%v, want %v..%v", got.Since, got.Until, tt.wantQuery.Since, tt.wantQuery.Until)
+			}
+			got.Since, got.Until = tt.wantQuery.Since, tt.wantQuery.Until
+			if got != tt.wantQuery {
+				t.Errorf("getChirps() query = %+v, want %+v", got, tt.wantQuery)
+			}
+		})
+	}
+}
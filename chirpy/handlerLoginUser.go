@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
 func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
@@ -26,19 +29,76 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if cfg.refuseLogin(w, r, params.Email) {
+		return
+	}
+
 	user, err := cfg.userDB.AuthenticateUser(params.Email, params.Password)
 	if err != nil {
+		cfg.loginFailed(r, params.Email)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	accessTokenString, err := createSignedString(
+	// with two-factor on, the password only earns a challenge token, to
+	// be exchanged at /api/login/2fa together with a code
+	tf, err := cfg.userDB.GetTwoFactor(user.Id)
+	if err != nil && !errors.Is(err, database.ErrTwoFactorNotFound) {
+		log.Printf("Could not look up two-factor: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if tf.Enabled {
+		challenge, err := createChallengeToken(user.Id, cfg.keys)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		type challengeResponse struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			ChallengeToken    string `json:"challenge_token"`
+		}
+		respondWithJSON(w, http.StatusOK, challengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
+		return
+	}
+
+	cfg.startSession(w, r, user)
+}
+
+// startSession logs user in: it starts a session and responds with the
+// user and their access and refresh tokens. The account's failed logins
+// are forgotten, though not those of the address it logged in from.
+func (cfg *apiConfig) startSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	user database.User,
+) {
+	if err := cfg.userDB.ClearLoginFailures(accountKey(user.Email)); err != nil {
+		log.Printf("Could not clear failed logins: %s", err)
+	}
+
+	refreshTokenString, err := makeToken()
+	if err != nil {
+		respondWithError(
+			w,
+			http.StatusInternalServerError,
+			"Something went wrong",
+		)
+		return
+	}
+
+	session, err := cfg.userDB.CreateSession(
 		user.Id,
-		"chirpy-access",
-		1*time.Hour,
-		cfg.secret,
+		r.UserAgent(),
+		clientIP(r),
+		refreshTokenString,
+		time.Now().Add(refreshTokenTTL),
 	)
 	if err != nil {
+		log.Printf("Could not start session: %s", err)
 		respondWithError(
 			w,
 			http.StatusInternalServerError,
@@ -47,11 +107,12 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshTokenString, err := createSignedString(
+	accessTokenString, err := createAccessToken(
 		user.Id,
-		"chirpy-refresh",
-		60*24*time.Hour,
-		cfg.secret,
+		session.ID,
+		user.Role,
+		accessTokenTTL,
+		cfg.keys,
 	)
 	if err != nil {
 		respondWithError(
@@ -62,11 +123,10 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	usr := user.ToSignedUser(accessTokenString, refreshTokenString)
-	log.Printf("/api/login/ returning user: %v", usr)
+	log.Printf("User %d logged in", user.Id)
 	respondWithJSON(
 		w,
 		http.StatusOK,
-		usr,
+		user.ToSignedUser(accessTokenString, refreshTokenString),
 	)
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+	"github.com/jsMRSoL/avian-din/internal/mail"
+)
+
+// forgotPassword mails a password reset token to the address given. The
+// response is the same whether or not anyone is registered with it, so
+// it can't be used to find out who has an account.
+func (cfg *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil || params.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	if err := cfg.sendPasswordReset(params.Email); err != nil {
+		log.Printf("Could not send password reset: %s", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendPasswordReset(email string) error {
+	userId, err := cfg.userDB.GetUserId(email)
+	if err != nil {
+		// nobody to send it to
+		return nil
+	}
+
+	token, err := makeToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.userDB.CreatePasswordReset(
+		userId,
+		token,
+		time.Now().Add(resetTokenTTL),
+	)
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your Chirpy account.\n\n"+
+				"To choose a new one, send this token to /api/password/reset "+
+				"within %s:\n\n%s\n\n"+
+				"If it wasn't you, you can ignore this email.\n",
+			resetTokenTTL,
+			token,
+		),
+	})
+}
+
+// resetPassword sets a new password using a token from forgotPassword.
+// Every session of the user is ended.
+func (cfg *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required")
+		return
+	}
+
+	user, err := cfg.userDB.ResetPassword(params.Token, params.Password)
+	if errors.Is(err, database.ErrResetInvalid) {
+		respondWithError(w, http.StatusBadRequest, "Reset token invalid/expired")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not reset password: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
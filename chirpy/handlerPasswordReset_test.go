@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/mail"
+)
+
+func Test_passwordReset(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	var outbox bytes.Buffer
+	cfg.mailer = mail.NewLogMailer(&outbox)
+	session := login(t, cfg, "phone")
+
+	post := func(handler http.HandlerFunc, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/password", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	// unknown addresses get the same answer, and no mail
+	if code := post(cfg.forgotPassword, `{"email":"gus@lospolloshermanos.com"}`); code != http.StatusAccepted {
+		t.Errorf("forgotPassword() unknown email = %d, want 202", code)
+	}
+	if outbox.Len() != 0 {
+		t.Errorf("forgotPassword() mailed an unknown address:\n%s", outbox.String())
+	}
+
+	if code := post(cfg.forgotPassword, `{"email":"walt@breakingbad.com"}`); code != http.StatusAccepted {
+		t.Fatalf("forgotPassword() = %d, want 202", code)
+	}
+	if !strings.Contains(outbox.String(), "To: walt@breakingbad.com") {
+		t.Fatalf("forgotPassword() sent:\n%s", outbox.String())
+	}
+	token := regexp.MustCompile(`(?m)^[0-9a-f]{64}\r$`).FindString(outbox.String())
+	if token == "" {
+		t.Fatalf("no reset token in mail:\n%s", outbox.String())
+	}
+	token = strings.TrimSpace(token)
+
+	if code := post(cfg.resetPassword, `{"token":"`+token+`"}`); code != http.StatusBadRequest {
+		t.Errorf("resetPassword() without a password = %d, want 400", code)
+	}
+	if code := post(cfg.resetPassword, `{"token":"`+token+`","password":"654321"}`); code != http.StatusOK {
+		t.Fatalf("resetPassword() = %d, want 200", code)
+	}
+	if code := post(cfg.resetPassword, `{"token":"`+token+`","password":"abcdef"}`); code != http.StatusBadRequest {
+		t.Errorf("resetPassword() reusing the token = %d, want 400", code)
+	}
+
+	// the old session is over and the new password works
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+	rec := httptest.NewRecorder()
+	cfg.middlewareAuth(cfg.getSessions)(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token from before the reset = %d, want 401", rec.Code)
+	}
+	loginAs(t, cfg, "walt@breakingbad.com", "654321", "laptop")
+}
@@ -1,56 +1,156 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
-func (cfg *apiConfig) upgradeUser(w http.ResponseWriter, r *http.Request) {
+const (
+	// webhookTolerance is how far a webhook's timestamp may be from our
+	// clock; older deliveries are refused so captured ones can't be
+	// replayed against a ledger that doesn't have their IDs, such as
+	// another instance's
+	webhookTolerance = 5 * time.Minute
+	maxWebhookBody   = 64 << 10
+)
+
+var errWebhookSignature = errors.New("webhook signature invalid")
+
+// signWebhook returns the signature Polka sends with body: the hex
+// HMAC-SHA256, keyed with the shared secret, of the timestamp, a dot and
+// the body
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhook checks that body was signed with secret at timestamp,
+// which must be within webhookTolerance of at. signatures is the
+// Polka-Signature header: comma separated "v1=<hex>" values, any of which
+// may match, so the secret can be rotated.
+func verifyWebhook(
+	secret string,
+	body []byte,
+	timestamp, signatures string,
+	at time.Time,
+) error {
+	if secret == "" {
+		return errors.New("no webhook secret configured")
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("webhook timestamp missing or malformed")
+	}
+	sent := time.Unix(unix, 0)
+	if sent.Before(at.Add(-webhookTolerance)) || sent.After(at.Add(webhookTolerance)) {
+		return errors.New("webhook timestamp outside tolerance")
+	}
 
-	authHeader := r.Header.Get("Authorization")
-	apikey := strings.Replace(authHeader, "ApiKey ", "", 1)
-	log.Println("> Received apikey: ", apikey)
-	log.Println("> Holding apikey: ", cfg.polkaApikey)
+	want := signWebhook(secret, timestamp, body)
+	for _, sig := range strings.Split(signatures, ",") {
+		got, ok := strings.CutPrefix(strings.TrimSpace(sig), "v1=")
+		if ok && hmac.Equal([]byte(got), []byte(want)) {
+			return nil
+		}
+	}
+	return errWebhookSignature
+}
 
-	if apikey != cfg.polkaApikey {
+// upgradeUser handles Polka's webhooks, which move users' Chirpy Red
+// subscriptions through their lifecycle. Each event is processed once:
+// its ID and raw payload go in a ledger, and redeliveries of an ID that
+// is there are acknowledged without doing anything.
+func (cfg *apiConfig) upgradeUser(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read body")
+		return
+	}
+	err = verifyWebhook(
+		cfg.polkaSecret,
+		body,
+		r.Header.Get("Polka-Timestamp"),
+		r.Header.Get("Polka-Signature"),
+		time.Now(),
+	)
+	if err != nil {
+		log.Printf("Rejected webhook from %s: %s", clientIP(r), err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
 	type parameters struct {
-		Event string
-		Data  map[string]int
+		ID    string         `json:"id"`
+		Event string         `json:"event"`
+		Data  map[string]int `json:"data"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err := decoder.Decode(&params)
+	err = json.Unmarshal(body, &params)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Something went wrong")
 		return
 	}
-
-	if params.Event != "user.upgraded" {
-		w.WriteHeader(http.StatusOK)
+	if params.ID == "" {
+		respondWithError(w, http.StatusBadRequest, "Event ID missing")
 		return
 	}
 
-	log.Println("> /api/polka/webhooks: received user.upgraded event ")
-	data := params.Data
-	userId, ok := data["user_id"]
-	if !ok {
-		log.Printf("> /api/polka/webhooks: no user_id obtained from %v", data)
-		w.WriteHeader(http.StatusOK)
-		return
+	var change *database.SubscriptionChange
+	if event := database.SubscriptionEvent(params.Event); event.Valid() {
+		userId, ok := params.Data["user_id"]
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "user_id missing")
+			return
+		}
+		change = &database.SubscriptionChange{UserID: userId, Event: event}
+		// period_end, in unix seconds, is sent with events that start or
+		// change a billing period
+		if end, ok := params.Data["period_end"]; ok {
+			change.PeriodEnd = time.Unix(int64(end), 0)
+		}
 	}
 
-	err = cfg.userDB.UpgradeUser(userId)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Something went wrong")
+	// the event is recorded and applied together, so a redelivery racing
+	// this one can't apply it again. Failed events aren't recorded, so
+	// Polka's retries can succeed; ones that don't apply to the
+	// subscription never will, so they are acknowledged and recorded.
+	_, err = cfg.userDB.ProcessWebhookEvent(database.WebhookEvent{
+		ID:      params.ID,
+		Event:   params.Event,
+		Payload: string(body),
+	}, change)
+	switch {
+	case errors.Is(err, database.ErrWebhookEventSeen):
+		log.Printf("Webhook event %s already processed", params.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	case errors.Is(err, database.ErrInvalidTransition):
+		log.Printf("Ignoring webhook event %s: %s", params.ID, err)
+	case errors.Is(err, database.ErrUserNotFound):
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	case err != nil:
+		log.Printf("Could not process webhook event %s: %s", params.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
+	log.Printf("Processed webhook event %s (%s)", params.ID, params.Event)
 
 	w.WriteHeader(http.StatusOK)
 }
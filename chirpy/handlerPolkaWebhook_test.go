@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_verifyWebhook(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"evt_1"}`)
+	ts := strconv.FormatInt(at.Unix(), 10)
+	sig := "v1=" + signWebhook("whsec", ts, body)
+
+	tests := []struct {
+		name       string
+		secret     string
+		body       []byte
+		timestamp  string
+		signatures string
+		wantErr    bool
+	}{
+		{"valid", "whsec", body, ts, sig, false},
+		{"one of several", "whsec", body, ts, "v1=00," + sig, false},
+		{"wrong secret", "other", body, ts, sig, true},
+		{"tampered body", "whsec", []byte(`{"id":"evt_2"}`), ts, sig, true},
+		{"no secret", "", body, ts, sig, true},
+		{"no signature", "whsec", body, ts, "", true},
+		{"no timestamp", "whsec", body, "", sig, true},
+		{
+			"stale",
+			"whsec",
+			body,
+			strconv.FormatInt(at.Add(-time.Hour).Unix(), 10),
+			"v1=" + signWebhook("whsec", strconv.FormatInt(at.Add(-time.Hour).Unix(), 10), body),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyWebhook(tt.secret, tt.body, tt.timestamp, tt.signatures, at)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_upgradeUser(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	cfg.polkaSecret = "whsec"
+
+	deliver := func(body, secret string) int {
+		t.Helper()
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+		req.Header.Set("Polka-Timestamp", ts)
+		req.Header.Set("Polka-Signature", "v1="+signWebhook(secret, ts, []byte(body)))
+		rec := httptest.NewRecorder()
+		cfg.upgradeUser(rec, req)
+		return rec.Code
+	}
+	upgrade := `{"id":"evt_1","event":"user.upgraded","data":{"user_id":1}}`
+
+	if code := deliver(upgrade, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("badly signed delivery = %d, want 401", code)
+	}
+	if user, _ := cfg.userDB.GetUser(1); user.IsChirpyRed {
+		t.Fatal("badly signed delivery upgraded the user")
+	}
+	if code := deliver(`{"event":"user.upgraded","data":{"user_id":1}}`, "whsec"); code != http.StatusBadRequest {
+		t.Errorf("delivery without an ID = %d, want 400", code)
+	}
+	if code := deliver(`{"id":"evt_0","event":"user.upgraded","data":{"user_id":9}}`, "whsec"); code != http.StatusNotFound {
+		t.Errorf("upgrading an unknown user = %d, want 404", code)
+	}
+	if _, err := cfg.userDB.GetWebhookEvent("evt_0"); err == nil {
+		t.Error("failed event was recorded, so it can't be retried")
+	}
+
+	if code := deliver(upgrade, "whsec"); code != http.StatusOK {
+		t.Fatalf("delivery = %d, want 200", code)
+	}
+	if user, _ := cfg.userDB.GetUser(1); !user.IsChirpyRed {
+		t.Error("user was not upgraded")
+	}
+	ev, err := cfg.userDB.GetWebhookEvent("evt_1")
+	if err != nil || ev.Payload != upgrade || ev.Event != "user.upgraded" {
+		t.Errorf("ledger entry = %+v, %v; want the raw payload", ev, err)
+	}
+	if code := deliver(upgrade, "whsec"); code != http.StatusOK {
+		t.Errorf("redelivery = %d, want 200", code)
+	}
+
+	// the rest of the lifecycle
+	end := time.Now().Add(time.Hour).Unix()
+	events := []struct {
+		body string
+		want database.SubscriptionStatus
+	}{
+		{`{"id":"evt_2","event":"payment.failed","data":{"user_id":1,"period_end":` + strconv.FormatInt(end, 10) + `}}`, database.SubscriptionPastDue},
+		{`{"id":"evt_3","event":"subscription.cancelled","data":{"user_id":1}}`, database.SubscriptionCancelled},
+		// doesn't apply once cancelled, but is acknowledged
+		{`{"id":"evt_4","event":"payment.failed","data":{"user_id":1}}`, database.SubscriptionCancelled},
+		{`{"id":"evt_5","event":"user.downgraded","data":{"user_id":1}}`, database.SubscriptionExpired},
+	}
+	for _, ev := range events {
+		if code := deliver(ev.body, "whsec"); code != http.StatusOK {
+			t.Fatalf("delivering %s = %d, want 200", ev.body, code)
+		}
+		user, _ := cfg.userDB.GetUser(1)
+		if user.Subscription.Status != ev.want {
+			t.Errorf("after %s subscription is %q, want %q", ev.body, user.Subscription.Status, ev.want)
+		}
+	}
+	if user, _ := cfg.userDB.GetUser(1); user.IsChirpyRed {
+		t.Error("downgraded user is still Red")
+	}
+
+	// storage failures are retried, not reported as a missing user
+	cfg.userDB = failingWebhookStore{cfg.userDB}
+	if code := deliver(`{"id":"evt_6","event":"user.upgraded","data":{"user_id":1}}`, "whsec"); code != http.StatusInternalServerError {
+		t.Errorf("delivery the store can't process = %d, want 500", code)
+	}
+}
+
+// failingWebhookStore is a user store that can't process webhook events
+type failingWebhookStore struct {
+	database.UserStore
+}
+
+func (failingWebhookStore) ProcessWebhookEvent(
+	database.WebhookEvent,
+	*database.SubscriptionChange,
+) (database.WebhookEvent, error) {
+	return database.WebhookEvent{}, errors.New("disk full")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+const (
+	maxDisplayName = 50
+	maxBio         = 160
+)
+
+var handleRe = regexp.MustCompile(`^[a-z0-9_]{3,20}$`)
+
+// reservedHandles would clash with the paths under /api/users
+var reservedHandles = map[string]bool{"me": true, "verify": true}
+
+// normalizeHandle lowercases handle and drops a leading @, so @Walt and
+// walt are the same handle
+func normalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(handle, "@"))
+}
+
+// validProfile checks a profile built from a request, with its handle
+// already normalized. An empty handle is allowed and clears it.
+func validProfile(profile database.Profile) error {
+	if profile.Handle != "" {
+		if !handleRe.MatchString(profile.Handle) {
+			return errors.New(
+				"Handle must be 3 to 20 letters, digits or underscores",
+			)
+		}
+		if reservedHandles[profile.Handle] {
+			return errors.New("Handle is not available")
+		}
+	}
+	if utf8.RuneCountInString(profile.DisplayName) > maxDisplayName {
+		return errors.New("Display name is too long")
+	}
+	if utf8.RuneCountInString(profile.Bio) > maxBio {
+		return errors.New("Bio is too long")
+	}
+	if profile.Avatar != "" {
+		u, err := url.Parse(profile.Avatar)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("Avatar must be an https URL")
+		}
+	}
+	return nil
+}
+
+// publicProfile is what anyone can see of a user
+type publicProfile struct {
+	Id int `json:"id"`
+	database.Profile
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// getProfile answers GET /api/users/{handle}
+func (cfg *apiConfig) getProfile(w http.ResponseWriter, r *http.Request) {
+	handle := normalizeHandle(r.PathValue("handle"))
+	user, err := cfg.userDB.GetUserByHandle(handle)
+	if errors.Is(err, database.ErrHandleNotFound) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not get user @%s: %s", handle, err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, publicProfile{
+		Id:        user.Id,
+		Profile:   user.Profile,
+		CreatedAt: user.CreatedAt,
+	})
+}
+
+// chirpAuthor is the compact author embedded in chirps on request
+type chirpAuthor struct {
+	Id          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Avatar      string `json:"avatar"`
+}
+
+// chirpResponse is a chirp as GET /api/chirps returns it. Author is only
+// set when the client asks for it with embed=author.
+type chirpResponse struct {
+	database.Chirp
+	Author *chirpAuthor `json:"author,omitempty"`
+}
+
+// chirpResponses prepares chirps for the response to r, embedding their
+// authors if it asks for them. Each author is looked up once, and chirps
+// whose author is gone or anonymous go without.
+func (cfg *apiConfig) chirpResponses(
+	r *http.Request,
+	chirps []database.Chirp,
+) []chirpResponse {
+	embed := false
+	for _, s := range strings.Split(r.URL.Query().Get("embed"), ",") {
+		if strings.TrimSpace(s) == "author" {
+			embed = true
+		}
+	}
+
+	authors := map[int]*chirpAuthor{}
+	resp := make([]chirpResponse, len(chirps))
+	for i, chirp := range chirps {
+		resp[i].Chirp = chirp
+		if !embed || chirp.AuthorId == database.AnonymousAuthor {
+			continue
+		}
+		author, seen := authors[chirp.AuthorId]
+		if !seen {
+			user, err := cfg.userDB.GetUser(chirp.AuthorId)
+			if err != nil {
+				log.Printf("Could not get author of chirp %d: %s", chirp.Id, err)
+			} else {
+				author = &chirpAuthor{
+					Id:          user.Id,
+					Handle:      user.Handle,
+					DisplayName: user.DisplayName,
+					Avatar:      user.Avatar,
+				}
+			}
+			authors[chirp.AuthorId] = author
+		}
+		resp[i].Author = author
+	}
+	return resp
+}
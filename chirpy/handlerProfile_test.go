@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_validProfile(t *testing.T) {
+	tests := map[string]struct {
+		profile database.Profile
+		wantErr bool
+	}{
+		"empty":         {profile: database.Profile{}},
+		"full":          {profile: database.Profile{Handle: "walt_w", DisplayName: "Walter White", Bio: "Chemistry teacher", Avatar: "https://example.com/walt.png"}},
+		"short handle":  {profile: database.Profile{Handle: "ww"}, wantErr: true},
+		"bad handle":    {profile: database.Profile{Handle: "walt-white"}, wantErr: true},
+		"reserved":      {profile: database.Profile{Handle: "me"}, wantErr: true},
+		"long name":     {profile: database.Profile{DisplayName: strings.Repeat("w", maxDisplayName+1)}, wantErr: true},
+		"runes in bio":  {profile: database.Profile{Bio: strings.Repeat("é", maxBio)}},
+		"long bio":      {profile: database.Profile{Bio: strings.Repeat("é", maxBio+1)}, wantErr: true},
+		"http avatar":   {profile: database.Profile{Avatar: "http://example.com/walt.png"}, wantErr: true},
+		"avatar no url": {profile: database.Profile{Avatar: "walt.png"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		if err := validProfile(tt.profile); (err != nil) != tt.wantErr {
+			t.Errorf("validProfile() %s error = %v, wantErr %v", name, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_profiles(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	if _, err := cfg.userDB.AddUser("jesse@breakingbad.com", "abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	walt := login(t, cfg, "laptop")
+	jesse := loginAs(t, cfg, "jesse@breakingbad.com", "abcdef", "phone")
+
+	patch := func(token, body string) (int, database.User) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPatch, "/api/users/me", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.middlewareAuth(cfg.updateUser)(rec, req)
+		user := database.User{}
+		json.Unmarshal(rec.Body.Bytes(), &user)
+		return rec.Code, user
+	}
+	get := func(handle string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/users/"+handle, nil)
+		req.SetPathValue("handle", handle)
+		rec := httptest.NewRecorder()
+		cfg.getProfile(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	code, user := patch(walt.AccessToken, `{"handle":"@Heisenberg","display_name":" Heisenberg ","bio":"Say my name"}`)
+	want := database.Profile{Handle: "heisenberg", DisplayName: "Heisenberg", Bio: "Say my name"}
+	if code != http.StatusOK || user.Profile != want {
+		t.Fatalf("profile update = %d, %+v; want %+v", code, user.Profile, want)
+	}
+	// fields left out are kept
+	if code, user := patch(walt.AccessToken, `{"avatar":"https://example.com/hat.png"}`); code != http.StatusOK || user.Handle != "heisenberg" {
+		t.Errorf("avatar update = %d, %+v; want the handle kept", code, user.Profile)
+	}
+	if code, _ := patch(jesse.AccessToken, `{"handle":"HEISENBERG"}`); code != http.StatusConflict {
+		t.Errorf("taking a used handle = %d, want 409", code)
+	}
+	if code, _ := patch(jesse.AccessToken, `{"handle":"x"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid handle = %d, want 400", code)
+	}
+	// a profile change alongside a credential change needs the password,
+	// and is not made without it
+	if code, _ := patch(jesse.AccessToken, `{"handle":"capn_cook","password":"fedcba"}`); code != http.StatusUnauthorized {
+		t.Errorf("profile and password change without the password = %d, want 401", code)
+	}
+	if code, _ := get("capn_cook"); code != http.StatusNotFound {
+		t.Errorf("handle set by a refused update = %d, want 404", code)
+	}
+	if code, _ := patch(jesse.AccessToken, `{"handle":"capn_cook","email":"walt@breakingbad.com","current_password":"abcdef"}`); code != http.StatusConflict {
+		t.Errorf("profile change with a taken email = %d, want 409", code)
+	}
+	if code, _ := get("capn_cook"); code != http.StatusNotFound {
+		t.Errorf("handle set alongside a taken email = %d, want 404", code)
+	}
+
+	code, body := get("@Heisenberg")
+	if code != http.StatusOK {
+		t.Fatalf("getProfile() = %d, want 200", code)
+	}
+	profile := publicProfile{}
+	json.Unmarshal([]byte(body), &profile)
+	if profile.Id != 1 || profile.Handle != "heisenberg" || profile.Avatar != "https://example.com/hat.png" {
+		t.Errorf("getProfile() = %+v, want walt's profile", profile)
+	}
+	if strings.Contains(body, "breakingbad.com") {
+		t.Errorf("getProfile() shows the email: %s", body)
+	}
+	if code, _ := get("nobody"); code != http.StatusNotFound {
+		t.Errorf("getProfile() unknown handle = %d, want 404", code)
+	}
+}
+
+func Test_getChirpsEmbedAuthor(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	profile := database.Profile{Handle: "heisenberg", DisplayName: "Heisenberg"}
+	if _, err := cfg.userDB.ChangeAccount(1, database.AccountChange{Profile: &profile}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.chirpsDB = &fakeChirpStore{
+		chirps: []database.Chirp{
+			{Id: 1, Body: "first", AuthorId: 1},
+			{Id: 2, Body: "gone", AuthorId: database.AnonymousAuthor},
+			{Id: 3, Body: "missing", AuthorId: 42},
+		},
+	}
+
+	get := func(url string) []chirpResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		cfg.getChirps(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("getChirps() = %d, want 200", rec.Code)
+		}
+		chirps := []chirpResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &chirps); err != nil {
+			t.Fatalf("could not decode response: %s", err)
+		}
+		return chirps
+	}
+
+	for _, chirp := range get("/api/chirps") {
+		if chirp.Author != nil {
+			t.Errorf("chirp %d has an author without embed", chirp.Id)
+		}
+	}
+	chirps := get("/api/chirps?embed=author")
+	want := chirpAuthor{Id: 1, Handle: "heisenberg", DisplayName: "Heisenberg"}
+	if len(chirps) != 3 || chirps[0].Author == nil || *chirps[0].Author != want {
+		t.Fatalf("getChirps() embed = %+v, want walt as the first author", chirps)
+	}
+	if chirps[1].Author != nil || chirps[2].Author != nil {
+		t.Errorf("anonymous or missing authors were embedded: %+v", chirps[1:])
+	}
+}
@@ -1,86 +1,57 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
-func getTokenAndStringFromHeader(
-	r *http.Request,
-	secret string,
-) (*jwt.Token, string, error) {
-	authHeader := r.Header.Get("Authorization")
-	tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(secret), nil
-		},
-	)
-	return token, tokenString, err
-}
-
-func parseToken(token *jwt.Token) (issuer string, authorId int, err error) {
-	issuer, err = token.Claims.GetIssuer()
-	if err != nil {
-		log.Printf("Could not get issuer from access token: %s", err)
-		return "", 0, err
-	}
-
-	idString, err := token.Claims.GetSubject()
-	if err != nil {
-		log.Printf("Could not get subject from access token: %s", err)
-		return "", 0, err
-	}
-
-	authorId, err = strconv.Atoi(idString)
-	if err != nil {
-		log.Printf("Could not convert token id string to int: %s", err)
-		return "", 0, err
-	}
-
-	return
-}
-
+// refreshAccessToken exchanges a refresh token for a new access token and
+// a new refresh token; the one presented can't be used again
 func (cfg *apiConfig) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
 
-	token, tokenString, err := getTokenAndStringFromHeader(r, cfg.secret)
-	// Authorization string `json:"Authorization"`
+	refreshTokenString, err := makeToken()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Token invalid/expired")
+		respondWithError(
+			w,
+			http.StatusInternalServerError,
+			"Something went wrong",
+		)
 		return
 	}
 
-	issuer, authorId, err := parseToken(token)
-
-	if issuer != "chirpy-refresh" {
-		respondWithError(w, http.StatusUnauthorized, "Bad token")
-		return
+	refreshToken, err := cfg.userDB.RotateRefreshToken(
+		bearerToken(r),
+		refreshTokenString,
+		time.Now().Add(refreshTokenTTL),
+	)
+	if errors.Is(err, database.ErrTokenReused) {
+		log.Printf(
+			"Refresh token reused for user %d; revoked its family",
+			refreshToken.UserID,
+		)
 	}
-
-	isRevoked, err := cfg.userDB.IsRevoked(tokenString)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Bad token")
 		return
 	}
-	if isRevoked {
+
+	// the role is looked up again so a change takes effect on refresh
+	user, err := cfg.userDB.GetUser(refreshToken.UserID)
+	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Bad token")
 		return
 	}
 
-	accessTokenString, err := createSignedString(
-		authorId,
-		"chirpy-access",
-		1*time.Hour,
-		cfg.secret,
+	accessTokenString, err := createAccessToken(
+		user.Id,
+		refreshToken.FamilyID,
+		user.Role,
+		accessTokenTTL,
+		cfg.keys,
 	)
 	if err != nil {
 		respondWithError(
@@ -91,11 +62,13 @@ func (cfg *apiConfig) refreshAccessToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	type accessToken struct {
-		Token string `json:"token"`
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
-	tkn := accessToken{
-		Token: accessTokenString,
+	tkn := tokens{
+		Token:        accessTokenString,
+		RefreshToken: refreshTokenString,
 	}
 
 	respondWithJSON(
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_refreshAccessToken(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	user := login(t, cfg, "test")
+
+	refresh := func(token string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.refreshAccessToken(rec, req)
+		resp := struct {
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code == http.StatusOK && resp.Token == "" {
+			t.Error("refresh returned no access token")
+		}
+		return rec.Code, resp.RefreshToken
+	}
+
+	code, second := refresh(user.RefreshToken)
+	if code != http.StatusOK || second == "" || second == user.RefreshToken {
+		t.Fatalf("first refresh = %d, %q; want a new refresh token", code, second)
+	}
+	code, third := refresh(second)
+	if code != http.StatusOK {
+		t.Fatalf("second refresh = %d, want 200", code)
+	}
+
+	// replaying a rotated token fails and takes the live one with it
+	if code, _ := refresh(user.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("replayed refresh = %d, want 401", code)
+	}
+	if code, _ := refresh(third); code != http.StatusUnauthorized {
+		t.Errorf("refresh after replay = %d, want 401", code)
+	}
+}
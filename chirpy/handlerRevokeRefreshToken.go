@@ -2,15 +2,12 @@ package main
 
 import (
 	"net/http"
-	"strings"
 )
 
+// revokeRefreshToken logs out the session the refresh token belongs to
 func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request) {
 
-	authHeader := r.Header.Get("Authorization")
-	tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-
-	err := cfg.userDB.AddRevokedToken(tokenString)
+	err := cfg.userDB.RevokeRefreshToken(bearerToken(r))
 	if err != nil {
 		respondWithError(
 			w,
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+// sessionResponse is a session as shown to its owner. Current marks the
+// session the request itself was made with.
+type sessionResponse struct {
+	Id         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+func (cfg *apiConfig) getSessions(w http.ResponseWriter, r *http.Request) {
+
+	p := requestPrincipal(r)
+
+	sessions, err := cfg.userDB.GetSessions(p.UserID)
+	if err != nil {
+		log.Printf("Could not retrieve sessions: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, sessionResponse{
+			Id:         s.ID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			Current:    s.ID == p.SessionID,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) deleteSession(w http.ResponseWriter, r *http.Request) {
+
+	userID := requestPrincipal(r).UserID
+
+	err := cfg.userDB.RevokeSession(userID, r.PathValue("ID"))
+	if errors.Is(err, database.ErrSessionNotFound) {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not revoke session: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// logoutAll ends every session of the user, including the current one
+func (cfg *apiConfig) logoutAll(w http.ResponseWriter, r *http.Request) {
+
+	userID := requestPrincipal(r).UserID
+
+	if err := cfg.userDB.RevokeSessions(userID); err != nil {
+		log.Printf("Could not revoke sessions: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+	"github.com/jsMRSoL/avian-din/internal/mail"
+)
+
+// setupAuthConfig returns an apiConfig backed by a fresh user store
+// holding one user, walt@breakingbad.com with password 123456
+func setupAuthConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	userDB, err := database.NewUserDB(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userDB.AddUser("walt@breakingbad.com", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := newKeyring("", "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{
+		userDB: userDB,
+		keys:   keys,
+		mailer: mail.NewLogMailer(io.Discard),
+	}
+}
+
+// login logs walt in from the given user agent
+func login(t *testing.T, cfg *apiConfig, userAgent string) database.SignedUser {
+	t.Helper()
+	return loginAs(t, cfg, "walt@breakingbad.com", "123456", userAgent)
+}
+
+// loginAs logs in the user registered with email
+func loginAs(
+	t *testing.T,
+	cfg *apiConfig,
+	email, password, userAgent string,
+) database.SignedUser {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body))
+	req.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	cfg.loginUser(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login = %d, want 200", rec.Code)
+	}
+	user := database.SignedUser{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+		t.Fatalf("could not decode login: %s", err)
+	}
+	return user
+}
+
+func Test_sessions(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	phone := login(t, cfg, "phone")
+	laptop := login(t, cfg, "laptop")
+
+	do := func(
+		handler http.HandlerFunc,
+		method, url, token string,
+	) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if id, ok := strings.CutPrefix(url, "/api/sessions/"); ok {
+			req.SetPathValue("ID", id)
+		}
+		rec := httptest.NewRecorder()
+		cfg.middlewareAuth(handler)(rec, req)
+		return rec
+	}
+	list := func(token string) (int, []sessionResponse) {
+		t.Helper()
+		rec := do(cfg.getSessions, http.MethodGet, "/api/sessions", token)
+		sessions := []sessionResponse{}
+		json.Unmarshal(rec.Body.Bytes(), &sessions)
+		return rec.Code, sessions
+	}
+
+	code, sessions := list(phone.AccessToken)
+	if code != http.StatusOK || len(sessions) != 2 {
+		t.Fatalf("getSessions() = %d, %v; want two sessions", code, sessions)
+	}
+	var laptopID string
+	for _, s := range sessions {
+		if s.UserAgent == "phone" && !s.Current {
+			t.Error("the phone's own session is not marked current")
+		}
+		if s.UserAgent == "laptop" {
+			laptopID = s.Id
+			if s.Current {
+				t.Error("the laptop's session is marked current")
+			}
+		}
+	}
+
+	rec := do(cfg.deleteSession, http.MethodDelete, "/api/sessions/unknown", phone.AccessToken)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("deleteSession() unknown = %d, want 404", rec.Code)
+	}
+	rec = do(cfg.deleteSession, http.MethodDelete, "/api/sessions/"+laptopID, phone.AccessToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("deleteSession() = %d, want 200", rec.Code)
+	}
+	// the laptop's access token stops working straight away
+	if code, _ := list(laptop.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("getSessions() with a revoked session = %d, want 401", code)
+	}
+	if code, sessions := list(phone.AccessToken); code != http.StatusOK || len(sessions) != 1 {
+		t.Errorf("getSessions() after delete = %d, %v; want one session", code, sessions)
+	}
+
+	rec = do(cfg.logoutAll, http.MethodPost, "/api/logout-all", phone.AccessToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logoutAll() = %d, want 200", rec.Code)
+	}
+	if code, _ := list(phone.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("getSessions() after logging out everywhere = %d, want 401", code)
+	}
+}
@@ -2,21 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 )
 
 func (cfg *apiConfig) postChirp(w http.ResponseWriter, r *http.Request) {
 
-	token, _, err := getTokenAndStringFromHeader(r, cfg.secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Token invalid/expired")
-		return
-	}
-
-	issuer, authorId, err := parseToken(token)
-	if issuer != "chirpy-access" {
-		respondWithError(w, http.StatusUnauthorized, "Bad token")
-	}
+	authorId := requestPrincipal(r).UserID
 
 	type parameters struct {
 		// these tags indicate how the keys in the JSON should be mapped to the struct fields
@@ -26,7 +18,7 @@ func (cfg *apiConfig) postChirp(w http.ResponseWriter, r *http.Request) {
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		// an error will be thrown if the JSON is invalid or has the wrong types
 		// any missing fields will simply have their values in the struct set to their zero value
@@ -43,6 +35,11 @@ func (cfg *apiConfig) postChirp(w http.ResponseWriter, r *http.Request) {
 	msg = cleanChirp(msg)
 
 	chirp, err := cfg.chirpsDB.StoreChirp(msg, authorId)
+	if err != nil {
+		log.Printf("Could not store chirp: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chirp")
+		return
+	}
 
 	respondWithJSON(w, http.StatusCreated, chirp)
 
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+	"github.com/jsMRSoL/avian-din/internal/totp"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+	// totpSkew is how many 30 second steps a code may be out by, to allow
+	// for slow typing and clocks that have drifted
+	totpSkew = 1
+)
+
+var errSecondFactor = errors.New("second factor was not accepted")
+
+// makeRecoveryCode returns a random code such as k3v7q-x2mdp-a9rfs-t4wzl
+func makeRecoveryCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:20]
+	return s[:5] + "-" + s[5:10] + "-" + s[10:15] + "-" + s[15:], nil
+}
+
+// checkSecondFactor accepts either a current TOTP code or one of the
+// user's recovery codes, using it up
+func (cfg *apiConfig) checkSecondFactor(userID int, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		err := cfg.userDB.UseRecoveryCode(userID, strings.ToLower(recoveryCode))
+		if errors.Is(err, database.ErrRecoveryCodeInvalid) {
+			return errSecondFactor
+		}
+		return err
+	}
+
+	tf, err := cfg.userDB.GetTwoFactor(userID)
+	if errors.Is(err, database.ErrTwoFactorNotFound) {
+		return errSecondFactor
+	}
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return errSecondFactor
+	}
+	step, ok := totp.Validate(tf.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return errSecondFactor
+	}
+	err = cfg.userDB.UseTOTPStep(userID, step)
+	if errors.Is(err, database.ErrCodeReused) {
+		return errSecondFactor
+	}
+	return err
+}
+
+type secondFactorParams struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// loginTwoFactor finishes a login for a user with two-factor on,
+// exchanging the challenge token from loginUser and a code for the usual
+// access and refresh tokens
+func (cfg *apiConfig) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		secondFactorParams
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+		return
+	}
+
+	userID, err := parseChallengeToken(params.ChallengeToken, cfg.keys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Challenge invalid/expired")
+		return
+	}
+	user, err := cfg.userDB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Challenge invalid/expired")
+		return
+	}
+
+	// wrong codes count as failed logins, so the password alone can't be
+	// used to guess them
+	if cfg.refuseLogin(w, r, user.Email) {
+		return
+	}
+	err = cfg.checkSecondFactor(userID, params.Code, params.RecoveryCode)
+	if errors.Is(err, errSecondFactor) {
+		cfg.loginFailed(r, user.Email)
+		respondWithError(w, http.StatusUnauthorized, "Code invalid")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not check second factor: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	cfg.startSession(w, r, user)
+}
+
+// enrollTwoFactor starts turning two-factor on. The secret is returned
+// both bare and as an otpauth URI for authenticator apps; it takes effect
+// once a code from it is sent to confirmTwoFactor.
+func (cfg *apiConfig) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	user, err := cfg.userDB.GetUser(requestPrincipal(r).UserID)
+	if err != nil {
+		log.Printf("Could not look up user: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	err = cfg.userDB.StartTwoFactor(user.Id, secret)
+	if errors.Is(err, database.ErrTwoFactorEnabled) {
+		respondWithError(w, http.StatusConflict, "Two-factor is already enabled")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not start two-factor: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	type enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	respondWithJSON(w, http.StatusOK, enrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// confirmTwoFactor turns two-factor on with a code from the secret
+// enrollTwoFactor handed out, and returns recovery codes. They are shown
+// this once.
+func (cfg *apiConfig) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	userID := requestPrincipal(r).UserID
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+		return
+	}
+
+	tf, err := cfg.userDB.GetTwoFactor(userID)
+	if errors.Is(err, database.ErrTwoFactorNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Enroll in two-factor first")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not look up two-factor: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if tf.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor is already enabled")
+		return
+	}
+	step, ok := totp.Validate(tf.Secret, params.Code, time.Now(), totpSkew)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Code invalid")
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = makeRecoveryCode(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		hashes[i] = database.HashRecoveryCode(codes[i])
+	}
+	if err := cfg.userDB.EnableTwoFactor(userID, step, hashes); err != nil {
+		log.Printf("Could not enable two-factor: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	type recoveryCodes struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithJSON(w, http.StatusOK, recoveryCodes{RecoveryCodes: codes})
+}
+
+// disableTwoFactor turns two-factor off. It takes the password and a
+// code, so a session left open somewhere isn't enough to do it.
+func (cfg *apiConfig) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	userID := requestPrincipal(r).UserID
+
+	type parameters struct {
+		Password string `json:"password"`
+		secondFactorParams
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+		return
+	}
+
+	user, err := cfg.userDB.GetUser(userID)
+	if err != nil {
+		log.Printf("Could not look up user: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if !cfg.confirmPassword(w, r, user, params.Password) {
+		return
+	}
+	// like at login, wrong codes count as failed logins so a stolen
+	// session and password can't be used to guess them
+	err = cfg.checkSecondFactor(userID, params.Code, params.RecoveryCode)
+	if errors.Is(err, errSecondFactor) {
+		cfg.loginFailed(r, user.Email)
+		respondWithError(w, http.StatusUnauthorized, "Code invalid")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not check second factor: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if err := cfg.userDB.DisableTwoFactor(userID); err != nil {
+		log.Printf("Could not disable two-factor: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
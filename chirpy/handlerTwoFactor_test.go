@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/totp"
+)
+
+func Test_twoFactor(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	session := login(t, cfg, "phone")
+
+	do := func(handler http.HandlerFunc, body, token string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/2fa", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+			handler = cfg.middlewareAuth(handler)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		resp := map[string]any{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	loginBody := `{"email":"walt@breakingbad.com","password":"123456"}`
+
+	if code, _ := do(cfg.confirmTwoFactor, `{"code":"123456"}`, session.AccessToken); code != http.StatusBadRequest {
+		t.Errorf("confirmTwoFactor() before enrolling = %d, want 400", code)
+	}
+	code, enrollment := do(cfg.enrollTwoFactor, "", session.AccessToken)
+	if code != http.StatusOK {
+		t.Fatalf("enrollTwoFactor() = %d, want 200", code)
+	}
+	secret, _ := enrollment["secret"].(string)
+	if uri, _ := enrollment["uri"].(string); !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("enrollTwoFactor() uri = %q, want it to hold the secret", uri)
+	}
+
+	// until it is confirmed, logging in needs only the password
+	if _, resp := do(cfg.loginUser, loginBody, ""); resp["token"] == nil {
+		t.Errorf("loginUser() with two-factor pending = %v, want tokens", resp)
+	}
+
+	now := totp.Step(time.Now())
+	current, _ := totp.Code(secret, now)
+	next, _ := totp.Code(secret, now+1)
+	if code, _ := do(cfg.confirmTwoFactor, `{"code":"000000x"}`, session.AccessToken); code != http.StatusBadRequest {
+		t.Errorf("confirmTwoFactor() wrong code = %d, want 400", code)
+	}
+	code, resp := do(cfg.confirmTwoFactor, `{"code":"`+current+`"}`, session.AccessToken)
+	codes, _ := resp["recovery_codes"].([]any)
+	if code != http.StatusOK || len(codes) != recoveryCodeCount {
+		t.Fatalf("confirmTwoFactor() = %d, %v; want recovery codes", code, resp)
+	}
+	if code, _ := do(cfg.enrollTwoFactor, "", session.AccessToken); code != http.StatusConflict {
+		t.Errorf("enrollTwoFactor() when enabled = %d, want 409", code)
+	}
+
+	// the password now only earns a challenge
+	challenge := func() string {
+		t.Helper()
+		_, resp := do(cfg.loginUser, loginBody, "")
+		if resp["token"] != nil || resp["two_factor_required"] != true {
+			t.Fatalf("loginUser() with two-factor = %v, want a challenge", resp)
+		}
+		return resp["challenge_token"].(string)
+	}
+	finish := func(challenge, factor string) (int, map[string]any) {
+		t.Helper()
+		return do(cfg.loginTwoFactor, `{"challenge_token":"`+challenge+`",`+factor+`}`, "")
+	}
+
+	c := challenge()
+	if code, _ := finish(c, `"code":"`+current+`"`); code != http.StatusUnauthorized {
+		t.Errorf("loginTwoFactor() with the code used to confirm = %d, want 401", code)
+	}
+	if code, _ := finish(session.AccessToken, `"code":"`+next+`"`); code != http.StatusUnauthorized {
+		t.Errorf("loginTwoFactor() with an access token = %d, want 401", code)
+	}
+	if code, resp := finish(c, `"code":"`+next+`"`); code != http.StatusOK || resp["token"] == nil {
+		t.Errorf("loginTwoFactor() = %d, %v; want tokens", code, resp)
+	}
+
+	recovery := codes[0].(string)
+	if code, resp := finish(challenge(), `"recovery_code":"`+recovery+`"`); code != http.StatusOK || resp["token"] == nil {
+		t.Errorf("loginTwoFactor() with a recovery code = %d, %v; want tokens", code, resp)
+	}
+	if code, _ := finish(challenge(), `"recovery_code":"`+recovery+`"`); code != http.StatusUnauthorized {
+		t.Errorf("loginTwoFactor() reusing a recovery code = %d, want 401", code)
+	}
+
+	disable := `{"password":"%s","recovery_code":"` + codes[1].(string) + `"}`
+	if code, _ := do(cfg.disableTwoFactor, strings.Replace(disable, "%s", "wrong", 1), session.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("disableTwoFactor() wrong password = %d, want 401", code)
+	}
+	// a wrong code counts toward a lockout, like a wrong password
+	before, _ := cfg.userDB.GetLoginThrottle(accountKey("walt@breakingbad.com"))
+	if code, _ := do(cfg.disableTwoFactor, `{"password":"123456","code":"000000"}`, session.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("disableTwoFactor() wrong code = %d, want 401", code)
+	}
+	if lt, _ := cfg.userDB.GetLoginThrottle(accountKey("walt@breakingbad.com")); lt.Failures != before.Failures+1 {
+		t.Errorf("failures after a wrong code = %d, want %d", lt.Failures, before.Failures+1)
+	}
+	if code, _ := do(cfg.disableTwoFactor, strings.Replace(disable, "%s", "123456", 1), session.AccessToken); code != http.StatusOK {
+		t.Fatalf("disableTwoFactor() = %d, want 200", code)
+	}
+	if _, resp := do(cfg.loginUser, loginBody, ""); resp["token"] == nil {
+		t.Errorf("loginUser() after disabling = %v, want tokens", resp)
+	}
+}
@@ -2,79 +2,127 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
-	// "time"
-
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsMRSoL/avian-din/internal/database"
 )
 
+// updateUser changes the fields of the user's account that are in the
+// request and leaves the rest alone. Changing the email or password needs
+// the current password too. A new email is only pending until it is
+// verified, and a new password logs the user's other sessions out. The
+// profile fields can be changed without the password. If any field can't
+// be changed, none are.
 func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 
-	// Authorization string `json:"Authorization"`
-	authHeader := r.Header.Get("Authorization")
-	tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
+	p := requestPrincipal(r)
 
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.secret), nil
-		},
-	)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Token invalid/expired")
-		return
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		Handle          *string `json:"handle"`
+		DisplayName     *string `json:"display_name"`
+		Bio             *string `json:"bio"`
+		Avatar          *string `json:"avatar"`
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Bad token")
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
 		return
 	}
 
-	if issuer == "chirpy-refresh" {
-		respondWithError(w, http.StatusUnauthorized, "Bad token")
+	if params.Email != nil && !validEmail(*params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email")
 		return
 	}
-
-	idString, err := token.Claims.GetSubject()
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Bad token")
+	if params.Password != nil && *params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password must not be empty")
 		return
 	}
 
-	id, err := strconv.Atoi(idString)
+	user, err := cfg.userDB.GetUser(p.UserID)
 	if err != nil {
-		log.Printf("String conversion error: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Bad token: no id")
+		log.Println(err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update db")
 		return
 	}
 
-	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+	profile := user.Profile
+	if params.Handle != nil {
+		profile.Handle = normalizeHandle(*params.Handle)
+	}
+	if params.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*params.DisplayName)
+	}
+	if params.Bio != nil {
+		profile.Bio = strings.TrimSpace(*params.Bio)
+	}
+	if params.Avatar != nil {
+		profile.Avatar = *params.Avatar
+	}
+	if err := validProfile(profile); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if (params.Email != nil || params.Password != nil) &&
+		!cfg.confirmPassword(w, r, user, params.CurrentPassword) {
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+	// everything changes together or not at all. A new email is only
+	// pending until it is verified; until then the old one stays in use.
+	change := database.AccountChange{KeepSession: p.SessionID}
+	if params.Password != nil {
+		change.Password = *params.Password
+	}
+	if params.Email != nil && *params.Email != user.Email {
+		token, err := makeToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		change.Email = &database.EmailChange{
+			Email:     *params.Email,
+			Token:     token,
+			ExpiresAt: time.Now().Add(verifyTokenTTL),
+		}
+	}
+	if profile != user.Profile {
+		change.Profile = &profile
+	}
+	if change.Password == "" && change.Email == nil && change.Profile == nil {
+		respondWithJSON(w, http.StatusOK, user)
 		return
 	}
 
-	user, err := cfg.userDB.UpdateUser(id, params.Email, params.Password)
+	user, err = cfg.userDB.ChangeAccount(p.UserID, change)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken")
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update db")
 		return
 	}
+	if change.Email != nil {
+		if err := cfg.mailVerification(change.Email.Email, change.Email.Token); err != nil {
+			// they can ask for another with /api/users/verify/resend
+			log.Printf("Could not send verification: %s", err)
+		}
+	}
 
 	respondWithJSON(w, http.StatusOK, user)
-	return
 }
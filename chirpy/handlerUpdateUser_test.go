@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_updateUser(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	if _, err := cfg.userDB.AddUser("jesse@breakingbad.com", "abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.userDB.UpgradeUser(1); err != nil {
+		t.Fatal(err)
+	}
+	laptop := login(t, cfg, "laptop")
+	login(t, cfg, "phone")
+
+	patch := func(body string) (int, database.User) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPatch, "/api/users/me", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+laptop.AccessToken)
+		rec := httptest.NewRecorder()
+		cfg.middlewareAuth(cfg.updateUser)(rec, req)
+		user := database.User{}
+		json.Unmarshal(rec.Body.Bytes(), &user)
+		return rec.Code, user
+	}
+
+	if code, user := patch(`{}`); code != http.StatusOK || user.Email != "walt@breakingbad.com" {
+		t.Errorf("empty update = %d, %+v; want the user unchanged", code, user)
+	}
+	if code, _ := patch(`{"password":"654321"}`); code != http.StatusUnauthorized {
+		t.Errorf("password change without the current one = %d, want 401", code)
+	}
+	if code, _ := patch(`{"password":"654321","current_password":"wrong"}`); code != http.StatusUnauthorized {
+		t.Errorf("password change with a wrong current one = %d, want 401", code)
+	}
+	if code, _ := patch(`{"email":"jesse@breakingbad.com","current_password":"123456"}`); code != http.StatusConflict {
+		t.Errorf("email change to a taken address = %d, want 409", code)
+	}
+	// a taken email stops the password changing with it
+	if code, _ := patch(`{"email":"jesse@breakingbad.com","password":"654321","current_password":"123456"}`); code != http.StatusConflict {
+		t.Errorf("password and taken email change = %d, want 409", code)
+	}
+	if sessions, _ := cfg.userDB.GetSessions(1); len(sessions) != 2 {
+		t.Errorf("refused change left %d sessions, want 2", len(sessions))
+	}
+	loginAs(t, cfg, "walt@breakingbad.com", "123456", "phone")
+	if code, _ := patch(`{"password":"","current_password":"123456"}`); code != http.StatusBadRequest {
+		t.Errorf("empty password = %d, want 400", code)
+	}
+
+	code, user := patch(`{"password":"654321","current_password":"123456"}`)
+	if code != http.StatusOK || user.Email != "walt@breakingbad.com" || !user.IsChirpyRed {
+		t.Fatalf("password change = %d, %+v; want the rest of the user kept", code, user)
+	}
+	// other sessions are logged out, but not this one
+	sessions, _ := cfg.userDB.GetSessions(1)
+	if len(sessions) != 1 || sessions[0].UserAgent != "laptop" {
+		t.Errorf("sessions after the password change = %+v, want only the laptop's", sessions)
+	}
+	loginAs(t, cfg, "walt@breakingbad.com", "654321", "desktop")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+// verifyEmail confirms an address with a token from sendVerification.
+// If the address was a pending change it becomes the user's email.
+func (cfg *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+		return
+	}
+
+	user, err := cfg.userDB.VerifyEmail(params.Token)
+	if errors.Is(err, database.ErrVerificationInvalid) {
+		respondWithError(w, http.StatusBadRequest, "Verification token invalid/expired")
+		return
+	}
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not verify email: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// resendVerification sends a new token for the user's pending address,
+// or for their current one if it hasn't been verified
+func (cfg *apiConfig) resendVerification(w http.ResponseWriter, r *http.Request) {
+
+	user, err := cfg.userDB.GetUser(requestPrincipal(r).UserID)
+	if err != nil {
+		log.Printf("Could not look up user: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	email := user.PendingEmail
+	if email == "" {
+		if user.Verified {
+			respondWithError(w, http.StatusBadRequest, "Email is already verified")
+			return
+		}
+		email = user.Email
+	}
+
+	_, err = cfg.sendVerification(user.Id, email)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, "Email is already registered")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not send verification: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
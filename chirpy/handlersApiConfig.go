@@ -3,15 +3,23 @@ package main
 import (
 	"fmt"
 	"github.com/jsMRSoL/avian-din/internal/database"
+	"github.com/jsMRSoL/avian-din/internal/mail"
 	"net/http"
 )
 
 type apiConfig struct {
 	fileserverHits int
-	chirpsDB       *database.DB
-	userDB         *database.UserDB
-	secret         string
-	polkaApikey    string
+	chirpsDB       database.ChirpStore
+	userDB         database.UserStore
+	keys           *keyring
+	mailer         mail.Mailer
+	// requireVerified holds the actions only users with a verified
+	// email may take
+	requireVerified verificationPolicy
+	// deletedChirps is what happens to the chirps of deleted accounts
+	deletedChirps chirpPolicy
+	// polkaSecret is the key Polka signs its webhooks with
+	polkaSecret string
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccountChange is a change to a user's account made by the user. It is
+// made all at once, or, if any part can't be, not at all.
+type AccountChange struct {
+	// Password is the new password; empty keeps the old one. Changing it
+	// ends the user's sessions other than KeepSession.
+	Password    string
+	KeepSession string
+	// Email, if set, becomes the user's pending address
+	Email *EmailChange
+	// Profile, if set, replaces the user's profile
+	Profile *Profile
+}
+
+// EmailChange is a new address for a user and the token that confirms it
+type EmailChange struct {
+	Email     string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// ChangeAccount makes change to the user's account. It returns
+// ErrEmailTaken or ErrHandleTaken, changing nothing, if the new address or
+// handle is someone else's.
+func (db *UserDB) ChangeAccount(userId int, change AccountChange) (User, error) {
+	pw := []byte{}
+	if change.Password != "" {
+		// Hash password before taking the lock; bcrypt is slow
+		var err error
+		pw, err = bcrypt.GenerateFromPassword([]byte(change.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	user := RegisteredUser{}
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		var ok bool
+		user, ok = dbStruct.Users[userId]
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("Database does not contain User ID: %d", userId),
+			)
+		}
+
+		if change.Profile != nil {
+			handle := change.Profile.Handle
+			if id, taken := dbStruct.Handles[handle]; taken && id != userId {
+				return nil, ErrHandleTaken
+			}
+			user.Profile = *change.Profile
+		}
+
+		at := now()
+		user.UpdatedAt = at
+		records := []userRecord{}
+		if change.Password != "" {
+			user.HashedPw = string(pw)
+			records = append(
+				records,
+				revokeSessionRecords(dbStruct, userId, change.KeepSession, at)...,
+			)
+		}
+		if ec := change.Email; ec != nil {
+			if id, taken := dbStruct.Addrs[ec.Email]; taken && id != userId {
+				return nil, ErrEmailTaken
+			}
+			ev := pendEmail(&user, ec.Email, ec.Token, ec.ExpiresAt, at)
+			records = append(records, userRecord{
+				Op:           opVerificationIssued,
+				User:         &user,
+				Verification: &ev,
+			})
+		} else {
+			records = append(records, userRecord{Op: opUserUpdated, User: &user})
+		}
+		return records, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user.toUser(), nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUserStore_ChangeAccount(t *testing.T) {
+	stopClock(t)
+	expires := testTime.Add(time.Hour)
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			walt, _ := store.AddUser("walt@breakingbad.com", "123456")
+			store.AddUser("jesse@breakingbad.com", "abcdef")
+			laptop, _ := store.CreateSession(walt.Id, "laptop", "", "laptop-token", expires)
+			store.CreateSession(walt.Id, "phone", "", "phone-token", expires)
+
+			// a taken address fails the whole change
+			_, err := store.ChangeAccount(walt.Id, AccountChange{
+				Password:    "654321",
+				KeepSession: laptop.ID,
+				Email:       &EmailChange{Email: "jesse@breakingbad.com", Token: "t1", ExpiresAt: expires},
+			})
+			if !errors.Is(err, ErrEmailTaken) {
+				t.Fatalf("ChangeAccount() to a taken email error = %v, want ErrEmailTaken", err)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "123456"); err != nil {
+				t.Errorf("password changed by a failed change: %v", err)
+			}
+			if sessions, _ := store.GetSessions(walt.Id); len(sessions) != 2 {
+				t.Errorf("failed change left %d sessions, want 2", len(sessions))
+			}
+
+			// and so does a taken handle
+			store.ChangeAccount(2, AccountChange{Profile: &Profile{Handle: "jesse"}})
+			_, err = store.ChangeAccount(walt.Id, AccountChange{
+				Password:    "654321",
+				KeepSession: laptop.ID,
+				Email:       &EmailChange{Email: "heisenberg@breakingbad.com", Token: "t1", ExpiresAt: expires},
+				Profile:     &Profile{Handle: "jesse"},
+			})
+			if !errors.Is(err, ErrHandleTaken) {
+				t.Fatalf("ChangeAccount() to a taken handle error = %v, want ErrHandleTaken", err)
+			}
+			if user, _ := store.GetUser(walt.Id); user.PendingEmail != "" {
+				t.Errorf("failed change left a pending email: %+v", user)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "123456"); err != nil {
+				t.Errorf("password changed by a failed change: %v", err)
+			}
+
+			user, err := store.ChangeAccount(walt.Id, AccountChange{
+				Password:    "654321",
+				KeepSession: laptop.ID,
+				Email:       &EmailChange{Email: "heisenberg@breakingbad.com", Token: "t2", ExpiresAt: expires},
+			})
+			if err != nil {
+				t.Fatalf("ChangeAccount() error = %v", err)
+			}
+			if user.Email != "walt@breakingbad.com" || user.PendingEmail != "heisenberg@breakingbad.com" {
+				t.Errorf("ChangeAccount() = %+v, want the new email pending", user)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "654321"); err != nil {
+				t.Errorf("new password not in use: %v", err)
+			}
+			sessions, _ := store.GetSessions(walt.Id)
+			if len(sessions) != 1 || sessions[0].ID != laptop.ID {
+				t.Errorf("sessions after the change = %+v, want only the laptop's", sessions)
+			}
+			if user, err := store.VerifyEmail("t2"); err != nil || user.Email != "heisenberg@breakingbad.com" {
+				t.Errorf("VerifyEmail() = %+v, %v; want the new email in use", user, err)
+			}
+		})
+	}
+}
+
+func TestUserStore_ChangeAccountKeepsState(t *testing.T) {
+	stopClock(t)
+	expires := testTime.Add(time.Hour)
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			walt, _ := store.AddUser("walt@breakingbad.com", "123456")
+			store.SetUserRole(walt.Id, RoleModerator)
+			store.UpdateSubscription(walt.Id, EventUpgraded, expires)
+			store.CreateEmailVerification(walt.Id, "walt@breakingbad.com", "tkn", expires)
+			store.VerifyEmail("tkn")
+
+			// a password change keeps everything else
+			got, err := store.ChangeAccount(walt.Id, AccountChange{Password: "654321"})
+			if err != nil {
+				t.Fatalf("ChangeAccount() error = %v", err)
+			}
+			if got.Email != "walt@breakingbad.com" || !got.IsChirpyRed ||
+				got.Role != RoleModerator || !got.Verified {
+				t.Errorf("ChangeAccount() with only a password = %+v, want the rest kept", got)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "654321"); err != nil {
+				t.Errorf("new password doesn't work: %v", err)
+			}
+
+			// an email change keeps the password and the role
+			_, err = store.ChangeAccount(walt.Id, AccountChange{
+				Email: &EmailChange{Email: "heisenberg@breakingbad.com", Token: "t1", ExpiresAt: expires},
+			})
+			if err != nil {
+				t.Fatalf("ChangeAccount() error = %v", err)
+			}
+			store.VerifyEmail("t1")
+			got, err = store.AuthenticateUser("heisenberg@breakingbad.com", "654321")
+			if err != nil {
+				t.Fatalf("password lost by changing email: %v", err)
+			}
+			if !got.IsChirpyRed || got.Role != RoleModerator {
+				t.Errorf("ChangeAccount() with only an email = %+v, want it Red and a moderator", got)
+			}
+		})
+	}
+}
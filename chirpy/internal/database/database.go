@@ -6,52 +6,102 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"slices"
 	"sync"
+	"time"
 )
 
 type DB struct {
-	path string
-	mu   *sync.RWMutex
+	path      string
+	mu        *sync.RWMutex
+	backups   int
+	compactAt int64
+	// data is the authoritative copy of the database; it is read from
+	// disk once and every change is written through to the log
+	data  DBStructure
+	index chirpIndex
+	watch *watchState
 }
 
 type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
+	Chirps    map[int]Chirp  `json:"chirps"`
+	Sequences map[string]int `json:"sequences"`
+}
+
+const chirpsSeq = "chirps"
+
+func (dbStruct DBStructure) clone() DBStructure {
+	return DBStructure{
+		Chirps:    maps.Clone(dbStruct.Chirps),
+		Sequences: maps.Clone(dbStruct.Sequences),
+	}
 }
 
 type Chirp struct {
-	Id       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorId int    `json:"author_id"`
+	Id        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorId  int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// chirpRecord is one change to the chirp store as written to the log
+type chirpRecord struct {
+	Seq   int    `json:"seq"`
+	Op    string `json:"op"`
+	Chirp Chirp  `json:"chirp"`
+	From  int    `json:"from,omitempty"` // the author an anonymized chirp had
+}
+
+const (
+	opChirpCreated    = "chirp.created"
+	opChirpDeleted    = "chirp.deleted"
+	opChirpAnonymized = "chirp.anonymized"
+)
+
+func (rec chirpRecord) apply(dbStruct *DBStructure) {
+	switch rec.Op {
+	case opChirpCreated:
+		dbStruct.Chirps[rec.Chirp.Id] = rec.Chirp
+		ensureSequence(dbStruct.Sequences, chirpsSeq, rec.Chirp.Id)
+	case opChirpDeleted:
+		delete(dbStruct.Chirps, rec.Chirp.Id)
+	case opChirpAnonymized:
+		dbStruct.Chirps[rec.Chirp.Id] = rec.Chirp
+	}
+	dbStruct.Sequences[logSeq] = rec.Seq
 }
 
-// NewDB creates a new database connection
-// and creates the database file if it doesn't exist
-func NewDB(path string) (*DB, error) {
+// NewDB creates a new database connection,
+// creates the database file if it doesn't exist
+// and loads it into memory
+func NewDB(path string, opts ...Option) (*DB, error) {
+	o := newFileOptions(opts)
 	db := &DB{
-		path: path,
-		mu:   &sync.RWMutex{},
+		path:      path,
+		mu:        &sync.RWMutex{},
+		backups:   o.backups,
+		compactAt: o.compactAt,
 	}
 	err := db.ensureDB()
 	return db, err
 }
 
 func (db *DB) StoreChirp(body string, authorId int) (Chirp, error) {
-	dbStruct, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
-	}
-
-	id := len(dbStruct.Chirps) + 1
-	chirp := Chirp{
-		Id:       id,
-		Body:     body,
-		AuthorId: authorId,
-	}
-
-	dbStruct.Chirps[id] = chirp
-	err = db.writeDB(dbStruct)
+	chirp := Chirp{}
+	err := db.commit(func(dbStruct *DBStructure) ([]chirpRecord, error) {
+		at := now()
+		chirp = Chirp{
+			Id:        dbStruct.Sequences[chirpsSeq] + 1,
+			Body:      body,
+			AuthorId:  authorId,
+			CreatedAt: at,
+			UpdatedAt: at,
+		}
+		return []chirpRecord{{Op: opChirpCreated, Chirp: chirp}}, nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
@@ -60,26 +110,20 @@ func (db *DB) StoreChirp(body string, authorId int) (Chirp, error) {
 }
 
 func (db *DB) DeleteChirp(chirpId int) error {
-	dbStruct, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	delete(dbStruct.Chirps, chirpId)
-
-	err = db.writeDB(dbStruct)
-	if err != nil {
-		return err
-	}
-	return nil
+	return db.commit(func(dbStruct *DBStructure) ([]chirpRecord, error) {
+		chirp, ok := dbStruct.Chirps[chirpId]
+		if !ok {
+			return nil, nil
+		}
+		return []chirpRecord{{Op: opChirpDeleted, Chirp: chirp}}, nil
+	})
 }
 
 func (db *DB) GetChirps(desc bool) ([]Chirp, error) {
-	dbStruct, err := db.loadDB()
-	if err != nil {
-		return nil, err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
+	dbStruct := db.data
 	chirps := make([]Chirp, 0, len(dbStruct.Chirps))
 	for _, v := range dbStruct.Chirps {
 		chirps = append(chirps, v)
@@ -101,12 +145,10 @@ func sortChirpSliceDesc(a, b Chirp) int {
 }
 
 func (db *DB) GetChirp(id int) (Chirp, error) {
-	dbStruct, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	chirp, ok := dbStruct.Chirps[id]
+	chirp, ok := db.data.Chirps[id]
 	if !ok {
 		return Chirp{}, errors.New(
 			fmt.Sprintf("Database does not contain Chirp ID: %d", id),
@@ -116,48 +158,110 @@ func (db *DB) GetChirp(id int) (Chirp, error) {
 }
 
 func (db *DB) ChirpsByAuthorID(authorId int, desc bool) ([]Chirp, error) {
-	dbStruct, err := db.loadDB()
-	if err != nil {
-		return nil, err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	chirps := []Chirp{}
-	for _, chirp := range dbStruct.Chirps {
-		if chirp.AuthorId == authorId {
-			chirps = append(chirps, chirp)
-		}
+	ids := db.index.byAuthor[authorId]
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirps = append(chirps, db.data.Chirps[id])
 	}
 
 	if desc {
-		slices.SortFunc(chirps, sortChirpSliceDesc)
-	} else {
-		slices.SortFunc(chirps, sortChirpSliceAsc)
+		slices.Reverse(chirps)
 	}
 
 	return chirps, nil
 }
 
 // ensureDB creates a new database file if it doesn't exist
+// and restores a damaged one from backup
 func (db *DB) ensureDB() error {
-	if _, err := os.ReadFile(db.path); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			dbStruct := DBStructure{
-				Chirps: map[int]Chirp{},
-			}
-			err := db.writeDB(dbStruct)
-			if err != nil {
-				return err
-			}
+	if _, err := os.Stat(db.path); errors.Is(err, os.ErrNotExist) {
+		dbStruct := DBStructure{
+			Chirps:    map[int]Chirp{},
+			Sequences: map[string]int{},
 		}
+		return db.writeDB(dbStruct)
 	}
-	return nil
+
+	// the file exists; if it is damaged fall back to the newest good backup
+	err := recoverFile(db.path, db.backups, func(data []byte) error {
+		return json.Unmarshal(data, &DBStructure{})
+	})
+	if err != nil {
+		return err
+	}
+
+	// read the snapshot and replay the log into memory
+	if err := repairLog[chirpRecord](logPath(db.path)); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.readDB()
+	if err != nil {
+		return err
+	}
+	stamped := stampChirps(dbStruct.Chirps, now())
+	db.setData(dbStruct)
+	if stamped {
+		// persist the migration so the stamps don't change on every start
+		return db.saveDB(dbStruct)
+	}
+	return db.compactIfNeeded(dbStruct)
+}
+
+// stampChirps gives chirps written before they carried timestamps the
+// time they were migrated at, and reports whether any needed it
+func stampChirps(chirps map[int]Chirp, at time.Time) bool {
+	stamped := false
+	for id, chirp := range chirps {
+		if !chirp.CreatedAt.IsZero() {
+			continue
+		}
+		chirp.CreatedAt = at
+		chirp.UpdatedAt = at
+		chirps[id] = chirp
+		stamped = true
+	}
+	return stamped
 }
 
-// loadDB reads the database file into memory
+// loadDB returns a copy of the database held in memory
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	return db.data.clone(), nil
+}
+
+// Reload replaces the copy held in memory with what is on disk.
+// It is needed only when another process has changed the files.
+func (db *DB) Reload() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.readDB()
+	if err != nil {
+		return err
+	}
+	db.setData(dbStruct)
+	db.watch.remember(db.path)
+	return nil
+}
+
+// setData replaces the copy held in memory and rebuilds the indexes
+// derived from it; callers must hold db.mu
+func (db *DB) setData(dbStruct DBStructure) {
+	db.data = dbStruct
+	db.index = buildChirpIndex(dbStruct.Chirps)
+}
+
+// readDB reads the snapshot and replays the log on top of it;
+// callers must hold db.mu
+func (db *DB) readDB() (DBStructure, error) {
 	dbStruct := DBStructure{}
 	data, err := os.ReadFile(db.path)
 	if err != nil {
@@ -169,21 +273,124 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return dbStruct, err
 	}
 
+	if dbStruct.Chirps == nil {
+		dbStruct.Chirps = map[int]Chirp{}
+	}
+	if dbStruct.Sequences == nil {
+		dbStruct.Sequences = map[string]int{}
+	}
+	ensureSequence(dbStruct.Sequences, chirpsSeq, maxKey(dbStruct.Chirps))
+
+	path := logPath(db.path)
+	records, _, err := readRecords[chirpRecord](path)
+	if err != nil {
+		log.Println(err)
+		return dbStruct, err
+	}
+	records, err = recordsAfter(path, records, dbStruct.Sequences[logSeq])
+	if err != nil {
+		log.Printf("--> DB: %s", err)
+		return dbStruct, err
+	}
+	for _, rec := range records {
+		rec.apply(&dbStruct)
+	}
+
 	return dbStruct, nil
 }
 
+// commit is how the store's own methods change data. fn looks at the
+// current state and returns records describing the change, without
+// modifying the state itself. The records are appended to the log, so a
+// change costs one small write rather than rewriting the whole file, and
+// are applied to the copy in memory once they are on disk.
+func (db *DB) commit(fn func(*DBStructure) ([]chirpRecord, error)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	records, err := fn(&db.data)
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	for i := range records {
+		records[i].Seq = db.data.Sequences[logSeq] + i + 1
+	}
+	if err := appendRecords(logPath(db.path), records); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		rec.apply(&db.data)
+		db.index.apply(rec)
+	}
+	db.watch.remember(db.path)
+
+	return db.compactIfNeeded(db.data)
+}
+
+// compactIfNeeded folds the log into a new snapshot once it is too big;
+// callers must hold db.mu
+func (db *DB) compactIfNeeded(dbStructure DBStructure) error {
+	size, err := logSize(logPath(db.path))
+	if err != nil {
+		return err
+	}
+	if size < db.compactAt {
+		return nil
+	}
+	return db.saveDB(dbStructure)
+}
+
+// Update runs fn as a read-modify-write transaction. The write lock is held
+// from reading the file until the result is written back, so concurrent
+// updates can't overwrite each other. If fn returns an error nothing is
+// written. The result is written as a full snapshot.
+//
+// fn works on a copy of the maps, so a failed transaction leaves the data
+// in memory untouched. Slices inside records are shared; replace them
+// rather than modifying them in place.
+func (db *DB) Update(fn func(*DBStructure) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct := db.data.clone()
+	if err := fn(&dbStruct); err != nil {
+		return err
+	}
+	if err := db.saveDB(dbStruct); err != nil {
+		return err
+	}
+	db.setData(dbStruct)
+	return nil
+}
+
 func (db *DB) writeDB(dbStructure DBStructure) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if err := db.saveDB(dbStructure); err != nil {
+		return err
+	}
+	db.setData(dbStructure)
+	return nil
+}
+
+// saveDB does the work of writeDB; callers must hold db.mu.
+// The snapshot includes everything in the log, so the log is removed.
+func (db *DB) saveDB(dbStructure DBStructure) error {
 	bytes, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(db.path, bytes, 0600)
+	err = writeFileAtomic(db.path, bytes, db.backups)
+	if err != nil {
+		return err
+	}
+	err = removeLog(logPath(db.path))
 	if err != nil {
 		return err
 	}
+	db.watch.remember(db.path)
 	return nil
 }
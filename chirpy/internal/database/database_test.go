@@ -1,7 +1,7 @@
 package database
 
 import (
-	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
@@ -11,11 +11,11 @@ func TestNewDB(t *testing.T) {
 	type args struct {
 		path string
 	}
-	// var db DB
-	// db.path = "./database.db"
+	path := filepath.Join(t.TempDir(), "database.db")
 	db := DB{
-		path: "./database.db",
-		mu:   &sync.RWMutex{},
+		path:      path,
+		mu:        &sync.RWMutex{},
+		compactAt: defaultCompactThreshold,
 	}
 
 	tests := []struct {
@@ -28,7 +28,7 @@ func TestNewDB(t *testing.T) {
 		{
 			name: "can create database",
 			args: args{
-				path: "./database.db",
+				path: path,
 			},
 			want:    &db,
 			wantErr: false,
@@ -41,6 +41,9 @@ func TestNewDB(t *testing.T) {
 				t.Errorf("NewDB() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// NewDB holds whatever is on disk in memory
+			data, _ := tt.want.readDB()
+			tt.want.setData(data)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewDB() = %v, want %v", got, tt.want)
 			}
@@ -48,16 +51,9 @@ func TestNewDB(t *testing.T) {
 	}
 }
 
+// setup opens an empty DB in a temporary directory
 func setup(t *testing.T) (*DB, error) {
-	_, err := os.Stat("./database.db")
-	if err == nil {
-		err := os.Remove("./database.db")
-		if err != nil {
-			t.Error("couldn't remove db file")
-			return nil, err
-		}
-	}
-	db, err := NewDB("./database.db")
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.db"))
 	if err != nil {
 		t.Error("choked on setup function!")
 		return nil, err
@@ -89,9 +85,11 @@ func TestDB_StoreChirp(t *testing.T) {
 				body:     "This is a test!",
 			},
 			want: Chirp{
-				Id:       1,
-				Body:     "This is a test!",
-				AuthorId: 1,
+				Id:        1,
+				Body:      "This is a test!",
+				AuthorId:  1,
+				CreatedAt: testTime,
+				UpdatedAt: testTime,
 			},
 			wantErr: false,
 		},
@@ -102,14 +100,17 @@ func TestDB_StoreChirp(t *testing.T) {
 				body:     "This is a second test!",
 			},
 			want: Chirp{
-				Id:       2,
-				Body:     "This is a second test!",
-				AuthorId: 2,
+				Id:        2,
+				Body:      "This is a second test!",
+				AuthorId:  2,
+				CreatedAt: testTime,
+				UpdatedAt: testTime,
 			},
 			wantErr: false,
 		},
 	}
 	// zero db
+	stopClock(t)
 	db, err := setup(t)
 	if err != nil {
 		t.Error("choked on setup function!")
@@ -153,20 +154,21 @@ func TestDB_GetChirpByID(t *testing.T) {
 		// TODO: Add test cases.
 		{
 			name:    "Can get chirp 1",
-			want:    Chirp{Id: 1, Body: "This is the first one", AuthorId: 1},
+			want:    Chirp{Id: 1, Body: "This is the first one", AuthorId: 1, CreatedAt: testTime, UpdatedAt: testTime},
 			id:      1,
 			msg:     "This is the first one",
 			wantErr: false,
 		},
 		{
 			name:    "Can get chirp 2",
-			want:    Chirp{Id: 2, Body: "This is the second one", AuthorId: 2},
+			want:    Chirp{Id: 2, Body: "This is the second one", AuthorId: 2, CreatedAt: testTime, UpdatedAt: testTime},
 			id:      2,
 			msg:     "This is the second one",
 			wantErr: false,
 		},
 	}
 	// zero db
+	stopClock(t)
 	db, err := setup(t)
 	if err != nil {
 		return
@@ -195,11 +197,11 @@ func TestDB_GetChirps(t *testing.T) {
 		path string
 	}
 	chirps := []Chirp{
-		{Id: 1, Body: "This is the first one", AuthorId: 1},
-		{Id: 2, Body: "This is the second one", AuthorId: 2},
-		{Id: 3, Body: "This is the third one", AuthorId: 3},
-		{Id: 4, Body: "This is the fourth one", AuthorId: 4},
-		{Id: 5, Body: "This is the fifth one", AuthorId: 5},
+		{Id: 1, Body: "This is the first one", AuthorId: 1, CreatedAt: testTime, UpdatedAt: testTime},
+		{Id: 2, Body: "This is the second one", AuthorId: 2, CreatedAt: testTime, UpdatedAt: testTime},
+		{Id: 3, Body: "This is the third one", AuthorId: 3, CreatedAt: testTime, UpdatedAt: testTime},
+		{Id: 4, Body: "This is the fourth one", AuthorId: 4, CreatedAt: testTime, UpdatedAt: testTime},
+		{Id: 5, Body: "This is the fifth one", AuthorId: 5, CreatedAt: testTime, UpdatedAt: testTime},
 	}
 	tests := []struct {
 		name    string
@@ -218,6 +220,7 @@ func TestDB_GetChirps(t *testing.T) {
 		},
 	}
 	// zero db
+	stopClock(t)
 	db, err := setup(t)
 	if err != nil {
 		t.Error("choked on setup function!")
@@ -246,16 +249,7 @@ func TestDB_GetChirps(t *testing.T) {
 func TestDB_ensureDB(t *testing.T) {
 	// test case 1
 	// no db file exists
-	_, err := os.Stat("./database.db")
-	if err == nil {
-		err := os.Remove("./database.db")
-		if err != nil {
-			t.Error("couldn't remove db file")
-			return
-		}
-	}
-	// set up variables
-	path := "./database.db"
+	path := filepath.Join(t.TempDir(), "database.db")
 	db, err := NewDB(path)
 	if err != nil {
 		t.Error("choked on setting up new db (never got to ensure_DB!)")
@@ -283,8 +277,11 @@ func TestDB_loadDB(t *testing.T) {
 	}{
 		// TODO: Add test cases.
 		{
-			name:    "can load db",
-			want:    DBStructure{map[int]Chirp{}},
+			name: "can load db",
+			want: DBStructure{
+				Chirps:    map[int]Chirp{},
+				Sequences: map[string]int{},
+			},
 			wantErr: false,
 		},
 	}
@@ -323,7 +320,7 @@ func TestDB_writeDB(t *testing.T) {
 			name: "Can write to empty db",
 			args: args{
 				dbStructure: DBStructure{
-					map[int]Chirp{
+					Chirps: map[int]Chirp{
 						1: {
 							Id:   1,
 							Body: "This is the first one!",
@@ -333,6 +330,7 @@ func TestDB_writeDB(t *testing.T) {
 							Body: "This is the second one!",
 						},
 					},
+					Sequences: map[string]int{"chirps": 2},
 				},
 			},
 			wantErr: false,
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// DeleteUser removes the user and everything kept for their account:
+// sessions and refresh tokens, password resets, email verifications and
+// two-factor settings. Their email is free to register again. Chirps are
+// a separate store; see DeleteChirpsByAuthor and AnonymizeChirps.
+func (db *UserDB) DeleteUser(id int) error {
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		user, ok := dbStruct.Users[id]
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("Database does not contain User ID: %d", id),
+			)
+		}
+		return []userRecord{{Op: opUserDeleted, User: &user, At: now()}}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("--> DB: deleted user %d", id)
+	return nil
+}
+
+// deleteUser drops user and everything that refers to them
+func deleteUser(dbStruct *UserDBStructure, user RegisteredUser) {
+	delete(dbStruct.Users, user.Id)
+	if dbStruct.Addrs[user.Email] == user.Id {
+		delete(dbStruct.Addrs, user.Email)
+	}
+	if user.Handle != "" && dbStruct.Handles[user.Handle] == user.Id {
+		delete(dbStruct.Handles, user.Handle)
+	}
+	for id, session := range dbStruct.Sessions {
+		if session.UserID == user.Id {
+			delete(dbStruct.Sessions, id)
+		}
+	}
+	for hash, rt := range dbStruct.RefreshTokens {
+		if rt.UserID == user.Id {
+			delete(dbStruct.RefreshTokens, hash)
+		}
+	}
+	for hash, pr := range dbStruct.PasswordResets {
+		if pr.UserID == user.Id {
+			delete(dbStruct.PasswordResets, hash)
+		}
+	}
+	for hash, ev := range dbStruct.EmailVerifications {
+		if ev.UserID == user.Id {
+			delete(dbStruct.EmailVerifications, hash)
+		}
+	}
+	delete(dbStruct.TwoFactor, user.Id)
+}
+
+// DeleteChirpsByAuthor deletes all of the author's chirps and returns how
+// many there were
+func (db *DB) DeleteChirpsByAuthor(authorId int) (int, error) {
+	n := 0
+	err := db.commit(func(dbStruct *DBStructure) ([]chirpRecord, error) {
+		records := []chirpRecord{}
+		for _, id := range db.index.byAuthor[authorId] {
+			records = append(records, chirpRecord{
+				Op:    opChirpDeleted,
+				Chirp: dbStruct.Chirps[id],
+			})
+		}
+		n = len(records)
+		return records, nil
+	})
+	return n, err
+}
+
+// AnonymizeChirps keeps the author's chirps but gives them no author,
+// which is AnonymousAuthor (-1), and returns how many there were
+func (db *DB) AnonymizeChirps(authorId int) (int, error) {
+	n := 0
+	err := db.commit(func(dbStruct *DBStructure) ([]chirpRecord, error) {
+		at := now()
+		records := []chirpRecord{}
+		for _, id := range db.index.byAuthor[authorId] {
+			chirp := dbStruct.Chirps[id]
+			chirp.AuthorId = AnonymousAuthor
+			chirp.UpdatedAt = at
+			records = append(records, chirpRecord{
+				Op:    opChirpAnonymized,
+				Chirp: chirp,
+				From:  authorId,
+			})
+		}
+		n = len(records)
+		return records, nil
+	})
+	return n, err
+}
+
+// AnonymousAuthor is the author of chirps whose author deleted their
+// account. User IDs start at 1, and 0 means any author in queries.
+const AnonymousAuthor = -1
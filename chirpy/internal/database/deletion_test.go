@@ -0,0 +1,105 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUserStore_DeleteUser(t *testing.T) {
+	stopClock(t)
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			walt, _ := store.AddUser("walt@breakingbad.com", "123456")
+			jesse, _ := store.AddUser("jesse@breakingbad.com", "abcdef")
+			exp := testTime.Add(time.Hour)
+			store.CreateSession(walt.Id, "laptop", "", "walt-refresh", exp)
+			store.CreateSession(jesse.Id, "phone", "", "jesse-refresh", exp)
+			store.CreatePasswordReset(walt.Id, "reset", exp)
+			store.StartTwoFactor(walt.Id, "SECRET")
+			store.RevokeSessions(walt.Id)
+			store.CreateSession(walt.Id, "desktop", "", "walt-refresh-2", exp)
+
+			if history, _ := store.SessionHistory(walt.Id); len(history) != 2 {
+				t.Errorf("SessionHistory() = %d sessions, want 2 with the revoked one", len(history))
+			}
+
+			if err := store.DeleteUser(walt.Id); err != nil {
+				t.Fatalf("DeleteUser() error = %v", err)
+			}
+			if _, err := store.GetUser(walt.Id); err == nil {
+				t.Error("GetUser() found the deleted user")
+			}
+			if history, _ := store.SessionHistory(walt.Id); len(history) != 0 {
+				t.Errorf("deleted user has %d sessions left", len(history))
+			}
+			if _, err := store.GetRefreshToken("walt-refresh-2"); err == nil {
+				t.Error("deleted user's refresh token still works")
+			}
+			if _, err := store.ResetPassword("reset", "654321"); err == nil {
+				t.Error("deleted user's password reset still works")
+			}
+			if _, err := store.GetTwoFactor(walt.Id); err == nil {
+				t.Error("deleted user's two-factor settings are left")
+			}
+			if err := store.DeleteUser(walt.Id); err == nil {
+				t.Error("deleting the user twice succeeded")
+			}
+
+			// the email can be registered again, with a new ID
+			again, err := store.AddUser("walt@breakingbad.com", "654321")
+			if err != nil {
+				t.Fatalf("AddUser() with a deleted user's email error = %v", err)
+			}
+			if again.Id == walt.Id {
+				t.Errorf("new user reused the deleted user's ID %d", walt.Id)
+			}
+			if sessions, _ := store.GetSessions(jesse.Id); len(sessions) != 1 {
+				t.Errorf("other user has %d sessions, want 1", len(sessions))
+			}
+		})
+	}
+}
+
+func TestChirpStore_deleteAuthor(t *testing.T) {
+	stopClock(t)
+	jsonDB, err := NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	stores := map[string]ChirpStore{
+		"json":   jsonDB,
+		"sqlite": setupSQLite(t),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			store.StoreChirp("one", 1)
+			store.StoreChirp("two", 2)
+			store.StoreChirp("three", 1)
+			store.StoreChirp("four", 3)
+			store.StoreChirp("five", 3)
+
+			n, err := store.AnonymizeChirps(1)
+			if err != nil || n != 2 {
+				t.Fatalf("AnonymizeChirps() = %d, %v; want 2", n, err)
+			}
+			if got, _ := store.ChirpsByAuthorID(1, false); len(got) != 0 {
+				t.Errorf("author still has chirps %v", chirpIDs(got))
+			}
+			got, _ := store.ChirpsByAuthorID(AnonymousAuthor, false)
+			if !reflect.DeepEqual(chirpIDs(got), []int{1, 3}) || got[0].Body != "one" {
+				t.Errorf("anonymous chirps = %+v, want 1 and 3 kept", got)
+			}
+
+			n, err = store.DeleteChirpsByAuthor(3)
+			if err != nil || n != 2 {
+				t.Fatalf("DeleteChirpsByAuthor() = %d, %v; want 2", n, err)
+			}
+			all, _ := store.GetChirps(false)
+			if !reflect.DeepEqual(chirpIDs(all), []int{1, 2, 3}) {
+				t.Errorf("chirps left = %v, want [1 2 3]", chirpIDs(all))
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Option configures the JSON file stores created by NewDB and NewUserDB
+type Option func(*fileOptions)
+
+type fileOptions struct {
+	backups   int
+	compactAt int64
+}
+
+// WithBackups keeps n rotated copies of the database file
+// (path.bak.1 is the newest) to recover from if the file is corrupted
+func WithBackups(n int) Option {
+	return func(o *fileOptions) {
+		o.backups = max(n, 0)
+	}
+}
+
+func newFileOptions(opts []Option) fileOptions {
+	o := fileOptions{compactAt: defaultCompactThreshold}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func backupPath(path string, generation int) string {
+	return fmt.Sprintf("%s.bak.%d", path, generation)
+}
+
+// writeFileAtomic replaces the file at path with data. The data is written
+// to a temporary file in the same directory, fsynced and renamed over path,
+// so a crash leaves either the old or the new file, never a truncated one.
+// When backups > 0 the old file is kept as path.bak.1 and older
+// generations are shifted along.
+func writeFileAtomic(path string, data []byte, backups int) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if backups > 0 {
+		if err := rotateBackups(path, backups); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rotateBackups shifts path.bak.N-1 to path.bak.N and so on, then makes the
+// current file path.bak.1. The current file stays in place throughout.
+func rotateBackups(path string, backups int) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	os.Remove(backupPath(path, backups))
+	for gen := backups - 1; gen >= 1; gen-- {
+		err := os.Rename(backupPath(path, gen), backupPath(path, gen+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	// a hard link keeps the old contents alive after the rename
+	// replaces path; fall back to copying where links aren't supported
+	if err := os.Link(path, backupPath(path, 1)); err != nil {
+		return copyFile(path, backupPath(path, 1))
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// recoverFile checks that the file at path can be decoded by valid. If it
+// can't, the newest backup that can is copied into its place and the
+// damaged file is kept as path.corrupt for inspection.
+func recoverFile(path string, backups int, valid func([]byte) error) error {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = valid(data)
+	}
+	if err == nil {
+		return nil
+	}
+	log.Printf("--> DB: %s is unreadable: %s", path, err)
+
+	for gen := 1; gen <= backups; gen++ {
+		bak := backupPath(path, gen)
+		data, err := os.ReadFile(bak)
+		if err != nil {
+			continue
+		}
+		if err := valid(data); err != nil {
+			log.Printf("--> DB: backup %s is unreadable: %s", bak, err)
+			continue
+		}
+
+		// don't overwrite the damaged file unless it was kept
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			return fmt.Errorf("keeping damaged %s: %w", path, err)
+		}
+		if err := writeFileAtomic(path, data, 0); err != nil {
+			return err
+		}
+		log.Printf("--> DB: restored %s from %s", path, bak)
+		return nil
+	}
+
+	return fmt.Errorf("%s is corrupt and no valid backup was found", path)
+}
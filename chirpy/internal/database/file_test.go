@@ -0,0 +1,116 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_writeFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	for _, content := range []string{"one", "two", "three", "four"} {
+		if err := writeFileAtomic(path, []byte(content), 2); err != nil {
+			t.Fatalf("writeFileAtomic(%q) error = %v", content, err)
+		}
+	}
+
+	want := map[string]string{
+		path:                "four",
+		backupPath(path, 1): "three",
+		backupPath(path, 2): "two",
+	}
+	for file, content := range want {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Errorf("reading %s: %s", file, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", file, got, content)
+		}
+	}
+	if _, err := os.Stat(backupPath(path, 3)); err == nil {
+		t.Errorf("%s exists, want only 2 generations", backupPath(path, 3))
+	}
+
+	// no temp files are left behind
+	matches, _ := filepath.Glob(path + ".tmp-*")
+	if len(matches) != 0 {
+		t.Errorf("temp files left behind: %v", matches)
+	}
+}
+
+func TestDB_recoverFromBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	// compact after every change so each one writes a snapshot
+	db, err := NewDB(path, WithBackups(2), WithCompactThreshold(1))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	if _, err := db.StoreChirp("first", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.StoreChirp("second", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash that left the primary file truncated
+	if err := os.WriteFile(path, []byte(`{"chirps":{"1":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDB(path, WithBackups(2))
+	if err != nil {
+		t.Fatalf("NewDB() did not recover: %v", err)
+	}
+	chirps, err := db.GetChirps(false)
+	if err != nil {
+		t.Fatalf("DB.GetChirps() error = %v", err)
+	}
+	// the newest backup predates the second chirp
+	if len(chirps) != 1 || chirps[0].Body != "first" {
+		t.Errorf("recovered chirps = %v, want only the first chirp", chirps)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("damaged file was not kept: %s", err)
+	}
+}
+
+func TestUserDB_corruptWithoutBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewUserDB(path); err == nil {
+		t.Error("NewUserDB() accepted a corrupt file with no backups")
+	}
+}
+
+func TestDB_recoverWithLogGap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	// the first chirp is folded into a snapshot, leaving the empty file
+	// as the backup
+	db, err := NewDB(path, WithBackups(2), WithCompactThreshold(1))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	if _, err := db.StoreChirp("first", 1); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDB(path, WithBackups(2))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	if _, err := db.StoreChirp("second", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"chirps":{"1":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the backup is missing the first chirp, which the log doesn't have
+	if _, err := NewDB(path, WithBackups(2)); err == nil {
+		t.Error("NewDB() started without the records between the backup and the log")
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import "slices"
+
+// chirpIndex holds chirp IDs in ascending order, overall and per author,
+// so that timelines and pages don't need a scan over every chirp. It is
+// derived from the chirps and rebuilt whenever they are loaded.
+type chirpIndex struct {
+	all      []int
+	byAuthor map[int][]int
+}
+
+func buildChirpIndex(chirps map[int]Chirp) chirpIndex {
+	idx := chirpIndex{
+		all:      make([]int, 0, len(chirps)),
+		byAuthor: map[int][]int{},
+	}
+	for _, chirp := range chirps {
+		idx.all = append(idx.all, chirp.Id)
+		idx.byAuthor[chirp.AuthorId] = append(idx.byAuthor[chirp.AuthorId], chirp.Id)
+	}
+	slices.Sort(idx.all)
+	for _, ids := range idx.byAuthor {
+		slices.Sort(ids)
+	}
+	return idx
+}
+
+func (idx *chirpIndex) add(chirp Chirp) {
+	idx.all = insertID(idx.all, chirp.Id)
+	idx.byAuthor[chirp.AuthorId] = insertID(idx.byAuthor[chirp.AuthorId], chirp.Id)
+}
+
+func (idx *chirpIndex) remove(chirp Chirp) {
+	idx.all = removeID(idx.all, chirp.Id)
+	ids := removeID(idx.byAuthor[chirp.AuthorId], chirp.Id)
+	if len(ids) == 0 {
+		delete(idx.byAuthor, chirp.AuthorId)
+		return
+	}
+	idx.byAuthor[chirp.AuthorId] = ids
+}
+
+// apply keeps the index in step with a record applied to the chirps
+func (idx *chirpIndex) apply(rec chirpRecord) {
+	switch rec.Op {
+	case opChirpCreated:
+		idx.add(rec.Chirp)
+	case opChirpDeleted:
+		idx.remove(rec.Chirp)
+	case opChirpAnonymized:
+		old := rec.Chirp
+		old.AuthorId = rec.From
+		idx.remove(old)
+		idx.add(rec.Chirp)
+	}
+}
+
+func insertID(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if found {
+		return ids
+	}
+	return slices.Insert(ids, i, id)
+}
+
+func removeID(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if !found {
+		return ids
+	}
+	return slices.Delete(ids, i, i+1)
+}
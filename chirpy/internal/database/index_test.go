@@ -0,0 +1,102 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestDB_authorIndex(t *testing.T) {
+	stopClock(t)
+	path := filepath.Join(t.TempDir(), "storage.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		if _, err := db.StoreChirp(fmt.Sprintf("chirp %d", i+1), i%2+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteChirp(3); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		got, err := db.ChirpsByAuthorID(1, true)
+		if err != nil {
+			t.Fatalf("DB.ChirpsByAuthorID() error = %v", err)
+		}
+		want := []Chirp{
+			{Id: 5, Body: "chirp 5", AuthorId: 1, CreatedAt: testTime, UpdatedAt: testTime},
+			{Id: 1, Body: "chirp 1", AuthorId: 1, CreatedAt: testTime, UpdatedAt: testTime},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DB.ChirpsByAuthorID() = %v, want %v", got, want)
+		}
+		got, _ = db.ChirpsByAuthorID(3, false)
+		if len(got) != 0 {
+			t.Errorf("DB.ChirpsByAuthorID() for unknown author = %v", got)
+		}
+	}
+	check(db)
+
+	// the index is rebuilt when the data is loaded again
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	check(reopened)
+}
+
+// chirpsByAuthorScan is how ChirpsByAuthorID worked before the index
+func chirpsByAuthorScan(dbStruct DBStructure, authorId int, desc bool) []Chirp {
+	chirps := []Chirp{}
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.AuthorId == authorId {
+			chirps = append(chirps, chirp)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		if desc {
+			return cmp.Compare(b.Id, a.Id)
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return chirps
+}
+
+func BenchmarkChirpsByAuthorID(b *testing.B) {
+	for _, size := range []int{1_000, 100_000} {
+		const authors = 100
+		dbStruct := DBStructure{
+			Chirps:    make(map[int]Chirp, size),
+			Sequences: map[string]int{chirpsSeq: size},
+		}
+		for id := 1; id <= size; id++ {
+			dbStruct.Chirps[id] = Chirp{Id: id, Body: "benchmark", AuthorId: id % authors}
+		}
+		db, err := NewDB(filepath.Join(b.TempDir(), "storage.db"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := db.writeDB(dbStruct); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("index/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				db.ChirpsByAuthorID(i%authors, true)
+			}
+		})
+		b.Run(fmt.Sprintf("scan/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				chirpsByAuthorScan(db.data, i%authors, true)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+type migration struct {
+	version int
+	name    string
+	stmts   string
+}
+
+// sqliteMigrations are applied in order and never edited once released;
+// schema changes are made by appending a new version.
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		name:    "create chirps, users and revoked tokens",
+		stmts: `
+CREATE TABLE chirps (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	body      TEXT    NOT NULL,
+	author_id INTEGER NOT NULL
+);
+CREATE TABLE users (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	email           TEXT    NOT NULL UNIQUE,
+	hashed_password TEXT    NOT NULL,
+	is_chirpy_red   INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE revoked_tokens (
+	token      TEXT     PRIMARY KEY,
+	revoked_at DATETIME NOT NULL
+);`,
+	},
+	{
+		version: 2,
+		name:    "index chirps by author",
+		stmts:   `CREATE INDEX chirps_author_id ON chirps (author_id, id);`,
+	},
+	{
+		version: 3,
+		name:    "add created_at and updated_at",
+		// existing rows are stamped with the time of the migration, to
+		// the second so the text sorts the same as times written by Go
+		stmts: `
+ALTER TABLE chirps ADD COLUMN created_at DATETIME NOT NULL DEFAULT '';
+ALTER TABLE chirps ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '';
+UPDATE chirps SET
+	created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'),
+	updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+ALTER TABLE users ADD COLUMN created_at DATETIME NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '';
+UPDATE users SET
+	created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'),
+	updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+CREATE INDEX chirps_created_at ON chirps (created_at, id);
+CREATE INDEX chirps_author_created_at ON chirps (author_id, created_at, id);`,
+	},
+	{
+		version: 4,
+		name:    "replace revoked tokens with stored refresh tokens",
+		// refresh tokens used to be JWTs; those can't be carried over,
+		// so their holders log in again
+		stmts: `
+DROP TABLE revoked_tokens;
+CREATE TABLE refresh_tokens (
+	hash       TEXT     PRIMARY KEY,
+	user_id    INTEGER  NOT NULL,
+	family_id  TEXT     NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	rotated_at DATETIME,
+	revoked_at DATETIME
+);
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at ON refresh_tokens (expires_at);`,
+	},
+	{
+		version: 5,
+		name:    "add sessions",
+		stmts: `
+CREATE TABLE sessions (
+	id           TEXT     PRIMARY KEY,
+	user_id      INTEGER  NOT NULL,
+	user_agent   TEXT     NOT NULL,
+	ip           TEXT     NOT NULL,
+	created_at   DATETIME NOT NULL,
+	last_used_at DATETIME NOT NULL,
+	expires_at   DATETIME NOT NULL,
+	revoked_at   DATETIME
+);
+CREATE INDEX sessions_user_id ON sessions (user_id);`,
+	},
+	{
+		version: 6,
+		name:    "add user roles",
+		stmts:   `ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';`,
+	},
+	{
+		version: 7,
+		name:    "add password resets",
+		stmts: `
+CREATE TABLE password_resets (
+	hash       TEXT     PRIMARY KEY,
+	user_id    INTEGER  NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at    DATETIME
+);
+CREATE INDEX password_resets_user_id ON password_resets (user_id);`,
+	},
+	{
+		version: 8,
+		name:    "add email verification",
+		// users registered before this count as verified
+		stmts: `
+ALTER TABLE users ADD COLUMN verified INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN pending_email TEXT NOT NULL DEFAULT '';
+UPDATE users SET verified = 1;
+CREATE TABLE email_verifications (
+	hash       TEXT     PRIMARY KEY,
+	user_id    INTEGER  NOT NULL,
+	email      TEXT     NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at    DATETIME
+);
+CREATE INDEX email_verifications_user_id ON email_verifications (user_id);`,
+	},
+	{
+		version: 9,
+		name:    "add two-factor authentication",
+		stmts: `
+CREATE TABLE two_factor (
+	user_id    INTEGER  PRIMARY KEY,
+	secret     TEXT     NOT NULL,
+	enabled    INTEGER  NOT NULL DEFAULT 0,
+	last_step  INTEGER  NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE TABLE recovery_codes (
+	user_id INTEGER NOT NULL,
+	hash    TEXT    NOT NULL,
+	PRIMARY KEY (user_id, hash)
+);`,
+	},
+	{
+		version: 10,
+		name:    "add login throttles",
+		stmts: `
+CREATE TABLE login_throttles (
+	key          TEXT     PRIMARY KEY,
+	failures     INTEGER  NOT NULL,
+	last_failure DATETIME NOT NULL,
+	locked_until DATETIME
+);
+CREATE INDEX login_throttles_last_failure ON login_throttles (last_failure);`,
+	},
+	{
+		version: 11,
+		name:    "add webhook events",
+		stmts: `
+CREATE TABLE webhook_events (
+	id           TEXT     PRIMARY KEY,
+	event        TEXT     NOT NULL,
+	payload      TEXT     NOT NULL,
+	processed_at DATETIME NOT NULL
+);`,
+	},
+	{
+		version: 12,
+		name:    "track subscriptions",
+		stmts: `
+ALTER TABLE users ADD COLUMN subscription_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN subscription_period_end DATETIME;
+UPDATE users SET subscription_status = 'active' WHERE is_chirpy_red = 1;
+ALTER TABLE users DROP COLUMN is_chirpy_red;
+CREATE INDEX users_subscription_period_end ON users (subscription_period_end);`,
+	},
+	{
+		version: 13,
+		name:    "add user profiles",
+		stmts: `
+ALTER TABLE users ADD COLUMN handle TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX users_handle ON users (handle) WHERE handle != '';`,
+	},
+}
+
+// migrate brings the schema up to the latest version. Each migration runs
+// in its own transaction together with its schema_migrations row.
+func migrate(db *sql.DB, migrations []migration) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER  PRIMARY KEY,
+	name       TEXT     NOT NULL,
+	applied_at DATETIME NOT NULL
+)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = db.QueryRow(
+		"SELECT COALESCE(MAX(version), 0) FROM schema_migrations",
+	).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].version
+	}
+	if current > latest {
+		return fmt.Errorf(
+			"database schema version %d is newer than supported version %d",
+			current,
+			latest,
+		)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("--> DB: applied migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.stmts); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version,
+		m.name,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// ChirpCursor marks a position in a list of chirps. CreatedAt is only
+// consulted when the list is sorted by creation time.
+type ChirpCursor struct {
+	Id        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChirpSort is the key a list of chirps is ordered by
+type ChirpSort int
+
+const (
+	SortByID ChirpSort = iota
+	// SortByCreatedAt orders by creation time, breaking ties by ID
+	SortByCreatedAt
+)
+
+// ChirpQuery selects one page of chirps. After and Before are positions
+// in the list as ordered by SortBy and Desc: After returns the chirps that
+// follow the cursor, Before the ones that precede it. With only Before set
+// the page is the Limit chirps just before the cursor.
+type ChirpQuery struct {
+	AuthorID int // 0 means every author
+	SortBy   ChirpSort
+	Desc     bool
+	Since    time.Time // created at or after; zero means no bound
+	Until    time.Time // created before; zero means no bound
+	After    *ChirpCursor
+	Before   *ChirpCursor
+	Limit    int // 0 means no limit
+}
+
+// ChirpPage is one page of chirps in list order. HasMore reports whether
+// more chirps lie beyond the page in the direction it was read: after it,
+// or before it when only Before was given.
+type ChirpPage struct {
+	Chirps  []Chirp
+	HasMore bool
+}
+
+// backwards reports whether the page is read towards the start of the list
+func (q ChirpQuery) backwards() bool {
+	return q.Before != nil && q.After == nil
+}
+
+// inRange reports whether t falls inside the Since/Until window
+func (q ChirpQuery) inRange(t time.Time) bool {
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !t.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// compare orders chirp against cursor c in ascending SortBy order
+func (q ChirpQuery) compare(chirp Chirp, c *ChirpCursor) int {
+	if q.SortBy == SortByCreatedAt {
+		if n := chirp.CreatedAt.Compare(c.CreatedAt); n != 0 {
+			return n
+		}
+	}
+	return cmp.Compare(chirp.Id, c.Id)
+}
+
+func compareChirps(a, b Chirp) int {
+	if n := a.CreatedAt.Compare(b.CreatedAt); n != 0 {
+		return n
+	}
+	return cmp.Compare(a.Id, b.Id)
+}
+
+// pageWindow cuts one page out of items, which are in ascending order as
+// judged by compare
+func pageWindow[T any](
+	items []T,
+	q ChirpQuery,
+	compare func(T, *ChirpCursor) int,
+) (page []T, hasMore bool) {
+	// bounds of the window in ascending order
+	lower, upper := q.After, q.Before
+	if q.Desc {
+		lower, upper = q.Before, q.After
+	}
+
+	start, end := 0, len(items)
+	if lower != nil {
+		var found bool
+		start, found = slices.BinarySearchFunc(items, lower, compare)
+		if found {
+			start++
+		}
+	}
+	if upper != nil {
+		end, _ = slices.BinarySearchFunc(items, upper, compare)
+	}
+	if start >= end {
+		return []T{}, false
+	}
+
+	if q.Limit > 0 && end-start > q.Limit {
+		hasMore = true
+		// the page sits at the low end of the window when reading
+		// forwards in ascending order or backwards in descending order
+		if q.Desc == q.backwards() {
+			end = start + q.Limit
+		} else {
+			start = end - q.Limit
+		}
+	}
+
+	page = slices.Clone(items[start:end])
+	if q.Desc {
+		slices.Reverse(page)
+	}
+	return page, hasMore
+}
+
+// ChirpsPage returns one page of chirps. Pages in ID order are read from
+// the index rather than sorting the whole collection; sorting by time or
+// filtering on it costs a pass over the author's chirps.
+func (db *DB) ChirpsPage(q ChirpQuery) (ChirpPage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ids := db.index.all
+	if q.AuthorID != 0 {
+		ids = db.index.byAuthor[q.AuthorID]
+	}
+
+	if q.SortBy == SortByID && q.Since.IsZero() && q.Until.IsZero() {
+		pageIds, hasMore := pageWindow(ids, q, func(id int, c *ChirpCursor) int {
+			return cmp.Compare(id, c.Id)
+		})
+		chirps := make([]Chirp, 0, len(pageIds))
+		for _, id := range pageIds {
+			chirps = append(chirps, db.data.Chirps[id])
+		}
+		return ChirpPage{Chirps: chirps, HasMore: hasMore}, nil
+	}
+
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		if chirp := db.data.Chirps[id]; q.inRange(chirp.CreatedAt) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	if q.SortBy == SortByCreatedAt {
+		slices.SortFunc(chirps, compareChirps)
+	}
+
+	page, hasMore := pageWindow(chirps, q, q.compare)
+	return ChirpPage{Chirps: page, HasMore: hasMore}, nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func chirpIDs(chirps []Chirp) []int {
+	ids := []int{}
+	for _, ch := range chirps {
+		ids = append(ids, ch.Id)
+	}
+	return ids
+}
+
+func TestChirpStore_ChirpsPage(t *testing.T) {
+	jsonDB, err := NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	stores := map[string]ChirpStore{
+		"json":   jsonDB,
+		"sqlite": setupSQLite(t),
+	}
+
+	tests := []struct {
+		name        string
+		query       ChirpQuery
+		wantIDs     []int
+		wantHasMore bool
+	}{
+		{
+			name:    "everything",
+			query:   ChirpQuery{},
+			wantIDs: []int{1, 2, 4, 5, 6, 7, 8},
+		},
+		{
+			name:        "first page",
+			query:       ChirpQuery{Limit: 3},
+			wantIDs:     []int{1, 2, 4},
+			wantHasMore: true,
+		},
+		{
+			name:        "after cursor",
+			query:       ChirpQuery{Limit: 3, After: &ChirpCursor{Id: 4}},
+			wantIDs:     []int{5, 6, 7},
+			wantHasMore: true,
+		},
+		{
+			name:    "last page",
+			query:   ChirpQuery{Limit: 3, After: &ChirpCursor{Id: 7}},
+			wantIDs: []int{8},
+		},
+		{
+			name:    "after a deleted chirp",
+			query:   ChirpQuery{Limit: 2, After: &ChirpCursor{Id: 3}},
+			wantIDs: []int{4, 5},
+			// 6, 7 and 8 remain
+			wantHasMore: true,
+		},
+		{
+			name:        "before cursor",
+			query:       ChirpQuery{Limit: 2, Before: &ChirpCursor{Id: 6}},
+			wantIDs:     []int{4, 5},
+			wantHasMore: true,
+		},
+		{
+			name: "between cursors",
+			query: ChirpQuery{
+				After:  &ChirpCursor{Id: 2},
+				Before: &ChirpCursor{Id: 7},
+			},
+			wantIDs: []int{4, 5, 6},
+		},
+		{
+			name:        "descending",
+			query:       ChirpQuery{Limit: 3, Desc: true},
+			wantIDs:     []int{8, 7, 6},
+			wantHasMore: true,
+		},
+		{
+			name:    "descending after cursor",
+			query:   ChirpQuery{Limit: 3, Desc: true, After: &ChirpCursor{Id: 4}},
+			wantIDs: []int{2, 1},
+		},
+		{
+			name:        "descending before cursor",
+			query:       ChirpQuery{Limit: 2, Desc: true, Before: &ChirpCursor{Id: 4}},
+			wantIDs:     []int{6, 5},
+			wantHasMore: true,
+		},
+		{
+			name:        "by author",
+			query:       ChirpQuery{AuthorID: 2, Limit: 2},
+			wantIDs:     []int{2, 4},
+			wantHasMore: true,
+		},
+		{
+			name: "by author descending after cursor",
+			query: ChirpQuery{
+				AuthorID: 2,
+				Desc:     true,
+				After:    &ChirpCursor{Id: 8},
+			},
+			wantIDs: []int{6, 4, 2},
+		},
+	}
+
+	for name, store := range stores {
+		// authors alternate 1, 2, 1, 2...; chirp 3 is deleted
+		for i := 1; i <= 8; i++ {
+			if _, err := store.StoreChirp("page", 2-i%2); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.DeleteChirp(3); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				got, err := store.ChirpsPage(tt.query)
+				if err != nil {
+					t.Fatalf("ChirpsPage() error = %v", err)
+				}
+				if ids := chirpIDs(got.Chirps); !reflect.DeepEqual(ids, tt.wantIDs) {
+					t.Errorf("ChirpsPage() ids = %v, want %v", ids, tt.wantIDs)
+				}
+				if got.HasMore != tt.wantHasMore {
+					t.Errorf("ChirpsPage() HasMore = %v, want %v", got.HasMore, tt.wantHasMore)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordReset is a password reset token as stored. Like refresh tokens,
+// only the hash is kept. A reset can be used once, and asking for a new
+// one replaces any the user still had.
+type PasswordReset struct {
+	Hash      string    `json:"hash"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at"` // zero until used
+}
+
+// ErrResetInvalid is returned for reset tokens that are unknown, expired,
+// replaced or already used
+var ErrResetInvalid = errors.New("password reset token is invalid")
+
+// usable reports whether the reset can still be used at time at
+func (pr PasswordReset) usable(at time.Time) bool {
+	return pr.UsedAt.IsZero() && at.Before(pr.ExpiresAt)
+}
+
+// CreatePasswordReset stores token as userID's password reset token
+func (db *UserDB) CreatePasswordReset(
+	userID int,
+	token string,
+	expiresAt time.Time,
+) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		if _, ok := dbStruct.Users[userID]; !ok {
+			return nil, errors.New("Could not get userID")
+		}
+		pr := PasswordReset{
+			Hash:      hashToken(token),
+			UserID:    userID,
+			CreatedAt: now(),
+			ExpiresAt: expiresAt.UTC(),
+		}
+		return []userRecord{{Op: opResetIssued, Reset: &pr}}, nil
+	})
+}
+
+// ResetPassword uses up token and sets its user's password to passwd.
+// The user's sessions are ended, since whoever holds them may be the
+// reason for the reset.
+func (db *UserDB) ResetPassword(token, passwd string) (User, error) {
+	pw, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := RegisteredUser{}
+	err = db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		at := now()
+		pr, ok := dbStruct.PasswordResets[hashToken(token)]
+		if !ok || !pr.usable(at) {
+			return nil, ErrResetInvalid
+		}
+		user, ok = dbStruct.Users[pr.UserID]
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("Database does not contain User ID: %d", pr.UserID),
+			)
+		}
+		user.HashedPw = string(pw)
+		user.UpdatedAt = at
+
+		records := []userRecord{
+			{Op: opPasswordReset, User: &user, Token: pr.Hash, At: at},
+		}
+		return append(records, revokeSessionRecords(dbStruct, user.Id, "", at)...), nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user.toUser(), nil
+}
+
+// putPasswordReset stores pr in place of userID's other resets. Resets
+// that had expired or been used by the time it was issued are dropped.
+func putPasswordReset(dbStruct *UserDBStructure, pr PasswordReset) {
+	for hash, old := range dbStruct.PasswordResets {
+		if old.UserID == pr.UserID || !old.usable(pr.CreatedAt) {
+			delete(dbStruct.PasswordResets, hash)
+		}
+	}
+	dbStruct.PasswordResets[pr.Hash] = pr
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUserStore_ResetPassword(t *testing.T) {
+	stopClock(t)
+	expires := testTime.Add(time.Hour)
+
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, err := store.AddUser("walt@breakingbad.com", "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.UpgradeUser(user.Id)
+			store.SetUserRole(user.Id, RoleModerator)
+			if _, err := store.CreateSession(user.Id, "", "", "refresh", expires); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.CreatePasswordReset(99, "nobody", expires); err == nil {
+				t.Error("CreatePasswordReset() accepted an unknown user")
+			}
+			if err := store.CreatePasswordReset(user.Id, "first", expires); err != nil {
+				t.Fatalf("CreatePasswordReset() error = %v", err)
+			}
+			// asking again replaces the first token
+			if err := store.CreatePasswordReset(user.Id, "second", expires); err != nil {
+				t.Fatalf("CreatePasswordReset() error = %v", err)
+			}
+			if _, err := store.ResetPassword("first", "654321"); !errors.Is(err, ErrResetInvalid) {
+				t.Errorf("ResetPassword() replaced token error = %v, want %v", err, ErrResetInvalid)
+			}
+			if _, err := store.ResetPassword("unknown", "654321"); !errors.Is(err, ErrResetInvalid) {
+				t.Errorf("ResetPassword() unknown token error = %v, want %v", err, ErrResetInvalid)
+			}
+
+			got, err := store.ResetPassword("second", "654321")
+			if err != nil {
+				t.Fatalf("ResetPassword() error = %v", err)
+			}
+			if got.Id != user.Id || !got.IsChirpyRed || got.Role != RoleModerator {
+				t.Errorf("ResetPassword() = %+v, want user %d with its state kept", got, user.Id)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "654321"); err != nil {
+				t.Errorf("AuthenticateUser() with the new password error = %v", err)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "123456"); err == nil {
+				t.Error("AuthenticateUser() accepted the old password")
+			}
+
+			// the token works once, and the old sessions are over
+			if _, err := store.ResetPassword("second", "abcdef"); !errors.Is(err, ErrResetInvalid) {
+				t.Errorf("ResetPassword() used token error = %v, want %v", err, ErrResetInvalid)
+			}
+			if sessions, _ := store.GetSessions(user.Id); len(sessions) != 0 {
+				t.Errorf("GetSessions() after reset = %v, want none", sessions)
+			}
+		})
+	}
+}
+
+func TestUserStore_ResetPasswordExpires(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			setClock(t, testTime)
+			user, _ := store.AddUser("walt@breakingbad.com", "123456")
+			if err := store.CreatePasswordReset(user.Id, "reset", testTime.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			setClock(t, testTime.Add(time.Hour))
+			if _, err := store.ResetPassword("reset", "654321"); !errors.Is(err, ErrResetInvalid) {
+				t.Errorf("ResetPassword() expired token error = %v, want %v", err, ErrResetInvalid)
+			}
+		})
+	}
+}
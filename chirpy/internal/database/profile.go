@@ -0,0 +1,42 @@
+package database
+
+import "errors"
+
+// Profile is what a user shows the public. Handle is unique among users,
+// and lowercase: callers normalize the ones they are given. Avatar is a
+// URL. Users start with an empty profile, and no handle.
+type Profile struct {
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Avatar      string `json:"avatar"`
+}
+
+var (
+	ErrHandleTaken    = errors.New("handle is already taken")
+	ErrHandleNotFound = errors.New("no user has that handle")
+)
+
+// GetUserByHandle returns the user with handle
+func (db *UserDB) GetUserByHandle(handle string) (User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	id, ok := db.data.Handles[handle]
+	if !ok || handle == "" {
+		return User{}, ErrHandleNotFound
+	}
+	user := db.data.Users[id]
+	return user.toUser(), nil
+}
+
+// indexHandles builds the handle index for files from before profiles
+func indexHandles(users map[int]RegisteredUser) map[string]int {
+	handles := map[string]int{}
+	for id, user := range users {
+		if user.Handle != "" {
+			handles[user.Handle] = id
+		}
+	}
+	return handles
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserStore_Profiles(t *testing.T) {
+	stopClock(t)
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			walt, _ := store.AddUser("walt@breakingbad.com", "123456")
+			jesse, _ := store.AddUser("jesse@breakingbad.com", "abcdef")
+			setProfile := func(userId int, profile Profile) (User, error) {
+				return store.ChangeAccount(userId, AccountChange{Profile: &profile})
+			}
+			if _, err := store.GetUserByHandle(""); !errors.Is(err, ErrHandleNotFound) {
+				t.Errorf("GetUserByHandle(\"\") error = %v, want ErrHandleNotFound", err)
+			}
+
+			profile := Profile{
+				Handle:      "heisenberg",
+				DisplayName: "Heisenberg",
+				Bio:         "I am the one who knocks",
+				Avatar:      "https://example.com/hat.png",
+			}
+			got, err := setProfile(walt.Id, profile)
+			if err != nil {
+				t.Fatalf("setting the profile error = %v", err)
+			}
+			if got.Profile != profile || got.Email != "walt@breakingbad.com" {
+				t.Errorf("setting the profile = %+v, want the profile set", got)
+			}
+			if got, err := store.GetUserByHandle("heisenberg"); err != nil || got.Id != walt.Id {
+				t.Errorf("GetUserByHandle() = %+v, %v; want walt", got, err)
+			}
+
+			if _, err := setProfile(jesse.Id, Profile{Handle: "heisenberg"}); !errors.Is(err, ErrHandleTaken) {
+				t.Errorf("setting the profile with a taken handle error = %v, want ErrHandleTaken", err)
+			}
+			// several users can have no handle
+			if _, err := setProfile(jesse.Id, Profile{DisplayName: "Jesse"}); err != nil {
+				t.Errorf("setting the profile without a handle error = %v", err)
+			}
+			// setting a profile again keeps the user's own handle, and
+			// changing it frees the old one
+			if _, err := setProfile(walt.Id, profile); err != nil {
+				t.Errorf("setting the profile again error = %v", err)
+			}
+			setProfile(walt.Id, Profile{Handle: "walt"})
+			if _, err := store.GetUserByHandle("heisenberg"); !errors.Is(err, ErrHandleNotFound) {
+				t.Errorf("old handle still found, error = %v", err)
+			}
+			if _, err := setProfile(jesse.Id, Profile{Handle: "heisenberg"}); err != nil {
+				t.Errorf("setting the profile with a freed handle error = %v", err)
+			}
+			// and so does deleting the account
+			store.DeleteUser(walt.Id)
+			if _, err := store.GetUserByHandle("walt"); !errors.Is(err, ErrHandleNotFound) {
+				t.Errorf("deleted user's handle still found, error = %v", err)
+			}
+		})
+	}
+}
+
+func TestUserDB_indexesHandles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	// a file written before the handle index
+	os.WriteFile(
+		path,
+		[]byte(`{"version":2,"users":{"1":{"id":1,"email":"old@example.com","password":"x","handle":"old"}},"addrs":{"old@example.com":1}}`),
+		0600,
+	)
+
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	if got, err := db.GetUserByHandle("old"); err != nil || got.Id != 1 {
+		t.Errorf("GetUserByHandle() = %+v, %v; want user 1", got, err)
+	}
+}
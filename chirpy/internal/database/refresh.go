@@ -0,0 +1,186 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// RefreshToken is a refresh token as stored. The token itself is never
+// kept, only its hash, so a copy of the store can't be used to log in.
+//
+// Every token belongs to a family, which is the session started at login;
+// FamilyID is the session's ID. Refreshing rotates the token: the old one
+// is marked rotated and a new one joins the family. Since only the newest
+// token in a family is live, an old one being presented means it was
+// copied, and the family is revoked.
+type RefreshToken struct {
+	Hash      string    `json:"hash"`
+	UserID    int       `json:"user_id"`
+	FamilyID  string    `json:"family_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RotatedAt time.Time `json:"rotated_at"` // zero until replaced
+	RevokedAt time.Time `json:"revoked_at"` // zero until revoked
+}
+
+var (
+	// ErrTokenInvalid is returned for tokens that are unknown,
+	// expired or revoked
+	ErrTokenInvalid = errors.New("refresh token is invalid")
+	// ErrTokenReused is returned when a rotated token is presented again;
+	// its family has been revoked
+	ErrTokenReused = errors.New("refresh token was reused")
+)
+
+// hashToken is how refresh tokens are looked up. The tokens are long
+// random strings, so a fast unsalted hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// usable reports whether the token can still be exchanged at time at
+func (rt RefreshToken) usable(at time.Time) bool {
+	return rt.RotatedAt.IsZero() && rt.RevokedAt.IsZero() && at.Before(rt.ExpiresAt)
+}
+
+// RotateRefreshToken exchanges old for next, which joins old's family.
+// If old has already been rotated the family is revoked, and old's record
+// is returned with ErrTokenReused.
+func (db *UserDB) RotateRefreshToken(
+	old, next string,
+	expiresAt time.Time,
+) (RefreshToken, error) {
+	rt := RefreshToken{}
+	reused := false
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		at := now()
+		cur, ok := dbStruct.RefreshTokens[hashToken(old)]
+		if !ok {
+			return nil, ErrTokenInvalid
+		}
+		if !cur.RotatedAt.IsZero() && cur.RevokedAt.IsZero() {
+			reused = true
+			rt = cur
+			return []userRecord{
+				{Op: opFamilyRevoked, Family: cur.FamilyID, At: at},
+			}, nil
+		}
+		if !cur.usable(at) {
+			return nil, ErrTokenInvalid
+		}
+
+		rt = RefreshToken{
+			Hash:      hashToken(next),
+			UserID:    cur.UserID,
+			FamilyID:  cur.FamilyID,
+			CreatedAt: at,
+			ExpiresAt: expiresAt.UTC(),
+		}
+		return []userRecord{
+			{Op: opRefreshRotated, Token: cur.Hash, At: at},
+			{Op: opRefreshIssued, Refresh: &rt, At: at},
+		}, nil
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if reused {
+		return rt, ErrTokenReused
+	}
+	return rt, nil
+}
+
+// RevokeRefreshToken revokes token's session and the rest of its family.
+// Unknown tokens are ignored.
+func (db *UserDB) RevokeRefreshToken(token string) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		rt, ok := dbStruct.RefreshTokens[hashToken(token)]
+		if !ok {
+			return nil, nil
+		}
+		return []userRecord{
+			{Op: opFamilyRevoked, Family: rt.FamilyID, At: now()},
+		}, nil
+	})
+}
+
+func (db *UserDB) GetRefreshToken(token string) (RefreshToken, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rt, ok := db.data.RefreshTokens[hashToken(token)]
+	if !ok {
+		return RefreshToken{}, ErrTokenInvalid
+	}
+	return rt, nil
+}
+
+// putRefreshToken stores rt and marks its session used
+func putRefreshToken(dbStruct *UserDBStructure, rt RefreshToken) {
+	dbStruct.RefreshTokens[rt.Hash] = rt
+
+	if session, ok := dbStruct.Sessions[rt.FamilyID]; ok {
+		session.LastUsedAt = rt.CreatedAt
+		session.ExpiresAt = rt.ExpiresAt
+		dbStruct.Sessions[rt.FamilyID] = session
+	}
+}
+
+// PruneRefreshTokens drops the refresh tokens that have expired and
+// returns how many there were. Their sessions are kept as history; they
+// ended when the tokens expired.
+func (db *UserDB) PruneRefreshTokens() (int, error) {
+	pruned := 0
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		at := now()
+		pruned = 0
+		for _, rt := range dbStruct.RefreshTokens {
+			if !at.Before(rt.ExpiresAt) {
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return nil, nil
+		}
+		return []userRecord{{Op: opRefreshPruned, At: at}}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// pruneRefreshTokens drops the tokens that had expired by at
+func pruneRefreshTokens(dbStruct *UserDBStructure, at time.Time) {
+	for hash, rt := range dbStruct.RefreshTokens {
+		if !at.Before(rt.ExpiresAt) {
+			delete(dbStruct.RefreshTokens, hash)
+		}
+	}
+}
+
+// revokeFamily revokes a session and every refresh token issued for it
+func revokeFamily(dbStruct *UserDBStructure, familyID string, at time.Time) {
+	for hash, rt := range dbStruct.RefreshTokens {
+		if rt.FamilyID == familyID && rt.RevokedAt.IsZero() {
+			rt.RevokedAt = at
+			dbStruct.RefreshTokens[hash] = rt
+		}
+	}
+	if session, ok := dbStruct.Sessions[familyID]; ok && session.RevokedAt.IsZero() {
+		session.RevokedAt = at
+		dbStruct.Sessions[familyID] = session
+	}
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupUserStores(t *testing.T) map[string]UserStore {
+	t.Helper()
+	userDB, err := NewUserDB(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	return map[string]UserStore{
+		"json":   userDB,
+		"sqlite": setupSQLite(t),
+	}
+}
+
+func TestUserStore_RefreshTokens(t *testing.T) {
+	stopClock(t)
+	expires := testTime.Add(time.Hour)
+
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, err := store.AddUser("walt@breakingbad.com", "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.CreateSession(99, "", "", "nobody", expires); err == nil {
+				t.Error("CreateSession() accepted an unknown user")
+			}
+
+			if _, err := store.CreateSession(user.Id, "", "", "first", expires); err != nil {
+				t.Fatalf("CreateSession() error = %v", err)
+			}
+			first, err := store.GetRefreshToken("first")
+			if err != nil {
+				t.Fatalf("GetRefreshToken() error = %v", err)
+			}
+			if first.Hash == "first" || first.UserID != user.Id {
+				t.Errorf("GetRefreshToken() = %+v", first)
+			}
+
+			second, err := store.RotateRefreshToken("first", "second", expires)
+			if err != nil {
+				t.Fatalf("RotateRefreshToken() error = %v", err)
+			}
+			if second.FamilyID != first.FamilyID || second.UserID != user.Id {
+				t.Errorf("RotateRefreshToken() = %+v, want family %s", second, first.FamilyID)
+			}
+			third, err := store.RotateRefreshToken("second", "third", expires)
+			if err != nil {
+				t.Fatalf("RotateRefreshToken() error = %v", err)
+			}
+
+			// a copy of the first token is played back
+			reused, err := store.RotateRefreshToken("first", "stolen", expires)
+			if !errors.Is(err, ErrTokenReused) || reused.UserID != user.Id {
+				t.Errorf("RotateRefreshToken() reuse = %+v, %v, want ErrTokenReused", reused, err)
+			}
+			// and the real client's token dies with the family
+			_, err = store.RotateRefreshToken("third", "fourth", expires)
+			if !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("RotateRefreshToken() after reuse error = %v, want ErrTokenInvalid", err)
+			}
+			if rt, _ := store.GetRefreshToken("third"); rt.RevokedAt.IsZero() || rt.FamilyID != third.FamilyID {
+				t.Errorf("family member after reuse = %+v, want revoked", rt)
+			}
+			if _, err := store.GetRefreshToken("stolen"); err == nil {
+				t.Error("RotateRefreshToken() stored a token for a reused one")
+			}
+
+			_, err = store.RotateRefreshToken("unknown", "next", expires)
+			if !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("RotateRefreshToken() unknown error = %v, want ErrTokenInvalid", err)
+			}
+		})
+	}
+}
+
+func TestUserStore_RevokeRefreshToken(t *testing.T) {
+	stopClock(t)
+	expires := testTime.Add(time.Hour)
+
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, _ := store.AddUser("walt@breakingbad.com", "123456")
+			store.CreateSession(user.Id, "", "", "phone", expires)
+			store.RotateRefreshToken("phone", "phone-2", expires)
+			store.CreateSession(user.Id, "", "", "laptop", expires)
+
+			if err := store.RevokeRefreshToken("phone-2"); err != nil {
+				t.Fatalf("RevokeRefreshToken() error = %v", err)
+			}
+			if err := store.RevokeRefreshToken("unknown"); err != nil {
+				t.Errorf("RevokeRefreshToken() unknown error = %v", err)
+			}
+			_, err := store.RotateRefreshToken("phone-2", "phone-3", expires)
+			if !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("RotateRefreshToken() revoked error = %v, want ErrTokenInvalid", err)
+			}
+			// other logins are untouched
+			if _, err := store.RotateRefreshToken("laptop", "laptop-2", expires); err != nil {
+				t.Errorf("RotateRefreshToken() other family error = %v", err)
+			}
+		})
+	}
+}
+
+func TestUserStore_RefreshTokenExpiry(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			setClock(t, testTime)
+			user, _ := store.AddUser("walt@breakingbad.com", "123456")
+			store.CreateSession(user.Id, "", "", "short", testTime.Add(time.Minute))
+
+			setClock(t, testTime.Add(time.Hour))
+			_, err := store.RotateRefreshToken("short", "next", testTime.Add(2*time.Hour))
+			if !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("RotateRefreshToken() expired error = %v, want ErrTokenInvalid", err)
+			}
+
+			// expired tokens are kept until they are pruned, and their
+			// sessions after that
+			store.CreateSession(user.Id, "", "", "later", testTime.Add(2*time.Hour))
+			if _, err := store.GetRefreshToken("short"); err != nil {
+				t.Errorf("expired token was dropped when the next was issued: %v", err)
+			}
+			if n, err := store.PruneRefreshTokens(); err != nil || n != 1 {
+				t.Errorf("PruneRefreshTokens() = %d, %v; want 1", n, err)
+			}
+			if _, err := store.GetRefreshToken("short"); err == nil {
+				t.Error("expired token was kept")
+			}
+			if _, err := store.GetRefreshToken("later"); err != nil {
+				t.Errorf("live token was pruned: %v", err)
+			}
+			history, _ := store.SessionHistory(user.Id)
+			if len(history) != 2 {
+				t.Errorf("SessionHistory() has %d sessions, want the expired one kept", len(history))
+			}
+		})
+	}
+}
+
+func TestUserDB_refreshTokensSurviveRestart(t *testing.T) {
+	stopClock(t)
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := db.AddUser("walt@breakingbad.com", "123456")
+	db.CreateSession(user.Id, "", "", "first", testTime.Add(time.Hour))
+	db.RotateRefreshToken("first", "second", testTime.Add(time.Hour))
+
+	// replaying the log must give the same state, reuse detection included
+	db, err = NewUserDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.RotateRefreshToken("first", "again", testTime.Add(time.Hour))
+	if !errors.Is(err, ErrTokenReused) {
+		t.Errorf("RotateRefreshToken() after restart error = %v, want ErrTokenReused", err)
+	}
+}
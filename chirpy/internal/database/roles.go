@@ -0,0 +1,76 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Role is what a user is allowed to do beyond managing their own account
+// and chirps. Each role can do everything the ones before it can.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ErrInvalidRole is returned when asked to give a user an unknown role
+var ErrInvalidRole = errors.New("invalid role")
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Includes reports whether a user with role r may do what other allows
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[other]
+}
+
+// SetUserRole gives the user role
+func (db *UserDB) SetUserRole(userId int, role Role) (User, error) {
+	if !role.Valid() {
+		return User{}, ErrInvalidRole
+	}
+
+	user := RegisteredUser{}
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		var ok bool
+		user, ok = dbStruct.Users[userId]
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("Database does not contain User ID: %d", userId),
+			)
+		}
+		user.Role = role
+		user.UpdatedAt = now()
+		return []userRecord{{Op: opUserRoleSet, User: &user}}, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user.toUser(), nil
+}
+
+// defaultRoles gives users registered before roles were added the user
+// role, and reports whether any needed it
+func defaultRoles(users map[int]RegisteredUser) bool {
+	changed := false
+	for id, user := range users {
+		if user.Role != "" {
+			continue
+		}
+		user.Role = RoleUser
+		users[id] = user
+		changed = true
+	}
+	return changed
+}
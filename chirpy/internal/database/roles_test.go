@@ -0,0 +1,85 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRole_Includes(t *testing.T) {
+	tests := []struct {
+		role  Role
+		other Role
+		want  bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleAdmin, RoleUser, true},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleUser, RoleModerator, false},
+		{Role(""), RoleUser, false},
+		{Role("owner"), RoleUser, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.other); got != tt.want {
+			t.Errorf("%q.Includes(%q) = %v, want %v", tt.role, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestUserStore_SetUserRole(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, err := store.AddUser("walt@breakingbad.com", "123456")
+			if err != nil {
+				t.Fatalf("AddUser() error = %v", err)
+			}
+			if user.Role != RoleUser {
+				t.Errorf("AddUser() role = %q, want %q", user.Role, RoleUser)
+			}
+
+			got, err := store.SetUserRole(user.Id, RoleAdmin)
+			if err != nil {
+				t.Fatalf("SetUserRole() error = %v", err)
+			}
+			if got.Role != RoleAdmin {
+				t.Errorf("SetUserRole() role = %q, want %q", got.Role, RoleAdmin)
+			}
+			if _, err := store.SetUserRole(user.Id, "owner"); !errors.Is(err, ErrInvalidRole) {
+				t.Errorf("SetUserRole() unknown role error = %v, want %v", err, ErrInvalidRole)
+			}
+			if _, err := store.SetUserRole(99, RoleAdmin); err == nil {
+				t.Error("SetUserRole() promoted a user that doesn't exist")
+			}
+
+			// changing the password doesn't take the role away
+			if _, err := store.ChangeAccount(user.Id, AccountChange{Password: "654321"}); err != nil {
+				t.Fatalf("ChangeAccount() error = %v", err)
+			}
+			got, _ = store.AuthenticateUser("walt@breakingbad.com", "654321")
+			if got.Role != RoleAdmin {
+				t.Errorf("role after ChangeAccount() = %q, want %q", got.Role, RoleAdmin)
+			}
+		})
+	}
+}
+
+func TestUserDB_defaultsOldUsersToUserRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	// a file written before users had roles
+	os.WriteFile(
+		path,
+		[]byte(`{"users":{"1":{"id":1,"email":"old@example.com","password":"x"}},"addrs":{"old@example.com":1}}`),
+		0600,
+	)
+
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	user, _ := db.GetUser(1)
+	if user.Role != RoleUser {
+		t.Errorf("migrated user role = %q, want %q", user.Role, RoleUser)
+	}
+}
@@ -0,0 +1,19 @@
+package database
+
+// ensureSequence makes sure the sequence is at least maxID. Records move
+// a sequence on as they are applied, so an ID is never handed out twice,
+// even after deletes. Files written before sequences existed have none,
+// so they start from the highest ID.
+func ensureSequence(seqs map[string]int, collection string, maxID int) {
+	if seqs[collection] < maxID {
+		seqs[collection] = maxID
+	}
+}
+
+func maxKey[V any](m map[int]V) int {
+	highest := 0
+	for k := range m {
+		highest = max(highest, k)
+	}
+	return highest
+}
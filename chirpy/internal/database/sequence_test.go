@@ -0,0 +1,133 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDB_StoreChirpUniqueIDs(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+
+	const n = 50
+	ids := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chirp, err := db.StoreChirp("parallel chirp", 1)
+			if err != nil {
+				t.Errorf("DB.StoreChirp() error = %v", err)
+				return
+			}
+			ids <- chirp.Id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[int]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("DB.StoreChirp() handed out ID %d twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != n {
+		t.Errorf("got %d unique IDs, want %d", len(seen), n)
+	}
+
+	chirps, err := db.GetChirps(false)
+	if err != nil {
+		t.Fatalf("DB.GetChirps() error = %v", err)
+	}
+	if len(chirps) != n {
+		t.Errorf("DB.GetChirps() returned %d chirps, want %d", len(chirps), n)
+	}
+}
+
+func TestDB_StoreChirpAfterDelete(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		db.StoreChirp("chirp", 1)
+	}
+	if err := db.DeleteChirp(2); err != nil {
+		t.Fatalf("DB.DeleteChirp() error = %v", err)
+	}
+	if err := db.DeleteChirp(3); err != nil {
+		t.Fatalf("DB.DeleteChirp() error = %v", err)
+	}
+
+	chirp, err := db.StoreChirp("after delete", 1)
+	if err != nil {
+		t.Fatalf("DB.StoreChirp() error = %v", err)
+	}
+	if chirp.Id != 4 {
+		t.Errorf("DB.StoreChirp() Id = %d, want 4", chirp.Id)
+	}
+}
+
+func TestDB_migrateSequence(t *testing.T) {
+	// a file from before sequences existed, with chirp 2 deleted
+	path := filepath.Join(t.TempDir(), "storage.db")
+	old := `{"chirps":{"1":{"id":1,"body":"one","author_id":1},"3":{"id":3,"body":"three","author_id":1}}}`
+	if err := os.WriteFile(path, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	chirp, err := db.StoreChirp("new", 1)
+	if err != nil {
+		t.Fatalf("DB.StoreChirp() error = %v", err)
+	}
+	if chirp.Id != 4 {
+		t.Errorf("DB.StoreChirp() Id = %d, want 4", chirp.Id)
+	}
+}
+
+func TestUserDB_AddUserUniqueIDs(t *testing.T) {
+	db, err := NewUserDB(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+
+	emails := []string{"a@x.com", "b@x.com", "c@x.com", "d@x.com", "e@x.com"}
+	ids := make(chan int, len(emails))
+	var wg sync.WaitGroup
+	for _, email := range emails {
+		wg.Add(1)
+		go func(email string) {
+			defer wg.Done()
+			user, err := db.AddUser(email, "password")
+			if err != nil {
+				t.Errorf("UserDB.AddUser() error = %v", err)
+				return
+			}
+			ids <- user.Id
+		}(email)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[int]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("UserDB.AddUser() handed out ID %d twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(emails) {
+		t.Errorf("got %d unique IDs, want %d", len(seen), len(emails))
+	}
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Session is one login. It lasts as long as the chain of refresh tokens
+// issued for it, and is revoked together with them.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"` // the last login or refresh
+	ExpiresAt  time.Time `json:"expires_at"`
+	RevokedAt  time.Time `json:"revoked_at"` // zero until revoked
+}
+
+// ErrSessionNotFound is returned for sessions that are unknown, belong to
+// someone else, or have ended
+var ErrSessionNotFound = errors.New("session not found")
+
+// Active reports whether the session can still be used at time at
+func (s Session) Active(at time.Time) bool {
+	return s.RevokedAt.IsZero() && at.Before(s.ExpiresAt)
+}
+
+// sortSessions puts the most recently used sessions first
+func sortSessions(sessions []Session) {
+	slices.SortFunc(sessions, func(a, b Session) int {
+		if n := b.LastUsedAt.Compare(a.LastUsedAt); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// CreateSession starts a session for userID with token as its first
+// refresh token
+func (db *UserDB) CreateSession(
+	userID int,
+	userAgent, ip, token string,
+	expiresAt time.Time,
+) (Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return Session{}, err
+	}
+
+	session := Session{}
+	err = db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		if _, ok := dbStruct.Users[userID]; !ok {
+			return nil, errors.New("Could not get userID")
+		}
+		at := now()
+		session = Session{
+			ID:         id,
+			UserID:     userID,
+			UserAgent:  userAgent,
+			IP:         ip,
+			CreatedAt:  at,
+			LastUsedAt: at,
+			ExpiresAt:  expiresAt.UTC(),
+		}
+		rt := RefreshToken{
+			Hash:      hashToken(token),
+			UserID:    userID,
+			FamilyID:  id,
+			CreatedAt: at,
+			ExpiresAt: expiresAt.UTC(),
+		}
+		return []userRecord{
+			{Op: opSessionStarted, Session: &session, Refresh: &rt, At: at},
+		}, nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// GetSession returns the session with the given ID, even if it has ended
+func (db *UserDB) GetSession(id string) (Session, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	session, ok := db.data.Sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// GetSessions returns userID's active sessions, most recently used first
+func (db *UserDB) GetSessions(userID int) ([]Session, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	at := now()
+	sessions := []Session{}
+	for _, session := range db.data.Sessions {
+		if session.UserID == userID && session.Active(at) {
+			sessions = append(sessions, session)
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+// SessionHistory returns all of userID's sessions, including those that
+// have ended, most recently used first
+func (db *UserDB) SessionHistory(userID int) ([]Session, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	sessions := []Session{}
+	for _, session := range db.data.Sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+// RevokeSession ends one of userID's sessions
+func (db *UserDB) RevokeSession(userID int, id string) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		session, ok := dbStruct.Sessions[id]
+		if !ok || session.UserID != userID || !session.Active(now()) {
+			return nil, ErrSessionNotFound
+		}
+		return []userRecord{
+			{Op: opFamilyRevoked, Family: id, At: now()},
+		}, nil
+	})
+}
+
+// RevokeSessions ends all of userID's sessions
+func (db *UserDB) RevokeSessions(userID int) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		return revokeSessionRecords(dbStruct, userID, "", now()), nil
+	})
+}
+
+// revokeSessionRecords returns the records that end userID's sessions
+// other than keep, which may be empty
+func revokeSessionRecords(
+	dbStruct *UserDBStructure,
+	userID int,
+	keep string,
+	at time.Time,
+) []userRecord {
+	records := []userRecord{}
+	for id, session := range dbStruct.Sessions {
+		if session.UserID == userID && session.RevokedAt.IsZero() && id != keep {
+			records = append(records, userRecord{
+				Op:     opFamilyRevoked,
+				Family: id,
+				At:     at,
+			})
+		}
+	}
+	return records
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func sessionIDs(sessions []Session) []string {
+	ids := []string{}
+	for _, s := range sessions {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestUserStore_Sessions(t *testing.T) {
+	expires := testTime.Add(24 * time.Hour)
+
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			setClock(t, testTime)
+			walt, _ := store.AddUser("walt@breakingbad.com", "123456")
+			jesse, _ := store.AddUser("jesse@breakingbad.com", "654321")
+
+			phone, err := store.CreateSession(walt.Id, "phone", "10.0.0.1", "phone", expires)
+			if err != nil {
+				t.Fatalf("CreateSession() error = %v", err)
+			}
+			if phone.UserAgent != "phone" || phone.IP != "10.0.0.1" || !phone.LastUsedAt.Equal(testTime) {
+				t.Errorf("CreateSession() = %+v", phone)
+			}
+			laptop, _ := store.CreateSession(walt.Id, "laptop", "10.0.0.2", "laptop", expires)
+			store.CreateSession(jesse.Id, "jesse", "10.0.0.3", "jesse", expires)
+
+			// refreshing marks the session used
+			used := testTime.Add(time.Hour)
+			setClock(t, used)
+			rt, err := store.RotateRefreshToken("phone", "phone-2", used.Add(24*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rt.FamilyID != phone.ID {
+				t.Errorf("rotated token belongs to %s, want session %s", rt.FamilyID, phone.ID)
+			}
+			got, _ := store.GetSession(phone.ID)
+			if !got.LastUsedAt.Equal(used) || !got.ExpiresAt.Equal(used.Add(24*time.Hour)) {
+				t.Errorf("session after refresh = %+v", got)
+			}
+
+			sessions, err := store.GetSessions(walt.Id)
+			if err != nil {
+				t.Fatalf("GetSessions() error = %v", err)
+			}
+			if ids := sessionIDs(sessions); len(ids) != 2 || ids[0] != phone.ID || ids[1] != laptop.ID {
+				t.Errorf("GetSessions() = %v, want [%s %s]", ids, phone.ID, laptop.ID)
+			}
+
+			// a session can only be ended by its owner
+			if err := store.RevokeSession(jesse.Id, phone.ID); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("RevokeSession() by another user error = %v, want ErrSessionNotFound", err)
+			}
+			if err := store.RevokeSession(walt.Id, phone.ID); err != nil {
+				t.Fatalf("RevokeSession() error = %v", err)
+			}
+			if err := store.RevokeSession(walt.Id, phone.ID); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("RevokeSession() twice error = %v, want ErrSessionNotFound", err)
+			}
+			if got, _ := store.GetSession(phone.ID); got.Active(used) {
+				t.Error("revoked session is still active")
+			}
+			if _, err := store.RotateRefreshToken("phone-2", "phone-3", expires); err == nil {
+				t.Error("revoked session's refresh token still works")
+			}
+			sessions, _ = store.GetSessions(walt.Id)
+			if ids := sessionIDs(sessions); len(ids) != 1 || ids[0] != laptop.ID {
+				t.Errorf("GetSessions() after revoke = %v, want [%s]", ids, laptop.ID)
+			}
+
+			if err := store.RevokeSessions(walt.Id); err != nil {
+				t.Fatalf("RevokeSessions() error = %v", err)
+			}
+			if sessions, _ := store.GetSessions(walt.Id); len(sessions) != 0 {
+				t.Errorf("GetSessions() after logging out everywhere = %v", sessionIDs(sessions))
+			}
+			if sessions, _ := store.GetSessions(jesse.Id); len(sessions) != 1 {
+				t.Errorf("other users' sessions were ended: %v", sessionIDs(sessions))
+			}
+		})
+	}
+}
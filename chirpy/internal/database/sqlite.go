@@ -0,0 +1,1664 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB stores chirps and users in a single SQLite database.
+// It implements both ChirpStore and UserStore.
+type SQLiteDB struct {
+	db *sql.DB
+}
+
+var (
+	_ ChirpStore = (*SQLiteDB)(nil)
+	_ UserStore  = (*SQLiteDB)(nil)
+)
+
+// NewSQLiteDB opens (or creates) the database at path
+// and applies any pending schema migrations
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	// _time_format stores times as text that SQLite's date functions
+	// understand; being UTC it also sorts in time order
+	dsn := fmt.Sprintf(
+		"file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite",
+		path,
+	)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialising through one connection
+	// avoids SQLITE_BUSY errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteDB{db: db}, nil
+}
+
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+const chirpColumns = "id, body, author_id, created_at, updated_at"
+
+// scanner is the Scan method shared by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanChirp(sc scanner) (Chirp, error) {
+	chirp := Chirp{}
+	err := sc.Scan(
+		&chirp.Id,
+		&chirp.Body,
+		&chirp.AuthorId,
+		&chirp.CreatedAt,
+		&chirp.UpdatedAt,
+	)
+	chirp.CreatedAt = chirp.CreatedAt.UTC()
+	chirp.UpdatedAt = chirp.UpdatedAt.UTC()
+	return chirp, err
+}
+
+func (s *SQLiteDB) StoreChirp(body string, authorId int) (Chirp, error) {
+	at := now()
+	res, err := s.db.Exec(
+		`INSERT INTO chirps (body, author_id, created_at, updated_at)
+		 VALUES (?, ?, ?, ?)`,
+		body,
+		authorId,
+		at,
+		at,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return Chirp{
+		Id:        int(id),
+		Body:      body,
+		AuthorId:  authorId,
+		CreatedAt: at,
+		UpdatedAt: at,
+	}, nil
+}
+
+func (s *SQLiteDB) DeleteChirp(chirpId int) error {
+	_, err := s.db.Exec("DELETE FROM chirps WHERE id = ?", chirpId)
+	return err
+}
+
+func (s *SQLiteDB) GetChirps(desc bool) ([]Chirp, error) {
+	query := "SELECT " + chirpColumns + " FROM chirps ORDER BY id"
+	if desc {
+		query += " DESC"
+	}
+	return s.queryChirps(query)
+}
+
+func (s *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	chirp, err := scanChirp(s.db.QueryRow(
+		"SELECT "+chirpColumns+" FROM chirps WHERE id = ?",
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New(
+			fmt.Sprintf("Database does not contain Chirp ID: %d", id),
+		)
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (s *SQLiteDB) ChirpsByAuthorID(authorId int, desc bool) ([]Chirp, error) {
+	query := "SELECT " + chirpColumns + " FROM chirps WHERE author_id = ? ORDER BY id"
+	if desc {
+		query += " DESC"
+	}
+	return s.queryChirps(query, authorId)
+}
+
+func (s *SQLiteDB) ChirpsPage(q ChirpQuery) (ChirpPage, error) {
+	where := []string{}
+	args := []any{}
+	if q.AuthorID != 0 {
+		where = append(where, "author_id = ?")
+		args = append(args, q.AuthorID)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.Until.UTC())
+	}
+
+	// cursors are compared with the sort key, as a row value when
+	// sorting by time so ties are broken by ID
+	key, placeholder := "id", "?"
+	keyArgs := func(c *ChirpCursor) []any { return []any{c.Id} }
+	if q.SortBy == SortByCreatedAt {
+		key, placeholder = "(created_at, id)", "(?, ?)"
+		keyArgs = func(c *ChirpCursor) []any {
+			return []any{c.CreatedAt.UTC(), c.Id}
+		}
+	}
+
+	lower, upper := q.After, q.Before
+	if q.Desc {
+		lower, upper = q.Before, q.After
+	}
+	if lower != nil {
+		where = append(where, key+" > "+placeholder)
+		args = append(args, keyArgs(lower)...)
+	}
+	if upper != nil {
+		where = append(where, key+" < "+placeholder)
+		args = append(args, keyArgs(upper)...)
+	}
+
+	// read from the end of the window the page is taken from
+	ascending := q.Desc == q.backwards()
+	direction := " DESC"
+	if ascending {
+		direction = " ASC"
+	}
+	query := "SELECT " + chirpColumns + " FROM chirps"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if q.SortBy == SortByCreatedAt {
+		query += " ORDER BY created_at" + direction + ", id" + direction
+	} else {
+		query += " ORDER BY id" + direction
+	}
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit+1)
+	}
+
+	chirps, err := s.queryChirps(query, args...)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	hasMore := q.Limit > 0 && len(chirps) > q.Limit
+	if hasMore {
+		chirps = chirps[:q.Limit]
+	}
+	// put the page back in list order
+	if ascending == q.Desc {
+		slices.Reverse(chirps)
+	}
+	return ChirpPage{Chirps: chirps, HasMore: hasMore}, nil
+}
+
+func (s *SQLiteDB) queryChirps(query string, args ...any) ([]Chirp, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+const userColumns = `id, email, subscription_status, subscription_period_end,
+	role, verified, pending_email, handle, display_name, bio, avatar,
+	created_at, updated_at`
+
+func scanUser(sc scanner, extra ...any) (User, error) {
+	user := User{}
+	var periodEnd sql.NullTime
+	dest := append([]any{
+		&user.Id,
+		&user.Email,
+		&user.Subscription.Status,
+		&periodEnd,
+		&user.Role,
+		&user.Verified,
+		&user.PendingEmail,
+		&user.Handle,
+		&user.DisplayName,
+		&user.Bio,
+		&user.Avatar,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	}, extra...)
+	err := sc.Scan(dest...)
+	if periodEnd.Valid {
+		user.Subscription.PeriodEnd = periodEnd.Time.UTC()
+	}
+	user.IsChirpyRed = user.Subscription.Red(now())
+	user.CreatedAt = user.CreatedAt.UTC()
+	user.UpdatedAt = user.UpdatedAt.UTC()
+	return user, err
+}
+
+func (s *SQLiteDB) AddUser(email string, passwd string) (User, error) {
+	pw, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	at := now()
+	res, err := s.db.Exec(
+		`INSERT INTO users (email, hashed_password, created_at, updated_at)
+		 VALUES (?, ?, ?, ?)
+		 ON CONFLICT (email) DO NOTHING`,
+		email,
+		string(pw),
+		at,
+		at,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+	if n == 0 {
+		return User{}, ErrEmailTaken
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+
+	return User{
+		Id:        int(id),
+		Email:     email,
+		Role:      RoleUser,
+		CreatedAt: at,
+		UpdatedAt: at,
+	}, nil
+}
+
+func (s *SQLiteDB) ChangeAccount(userId int, change AccountChange) (User, error) {
+	pw := ""
+	if change.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(change.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, err
+		}
+		pw = string(hash)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := getUserTx(tx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New(
+			fmt.Sprintf("Database does not contain User ID: %d", userId),
+		)
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	at := now()
+	_, err = tx.Exec(
+		`UPDATE users SET
+			hashed_password = CASE WHEN ?1 = '' THEN hashed_password ELSE ?1 END,
+			updated_at = ?2
+		 WHERE id = ?3`,
+		pw,
+		at,
+		userId,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	if pw != "" {
+		if err := revokeSessionsSQL(tx, userId, change.KeepSession, at); err != nil {
+			return User{}, err
+		}
+	}
+	if ec := change.Email; ec != nil {
+		if err := pendEmailTx(tx, user, ec.Email, ec.Token, ec.ExpiresAt, at); err != nil {
+			return User{}, err
+		}
+	}
+	if change.Profile != nil {
+		if err := setProfileTx(tx, userId, *change.Profile, at); err != nil {
+			return User{}, err
+		}
+	}
+
+	user, err = getUserTx(tx, userId)
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// UpdateSubscription applies event to the user's subscription
+func (s *SQLiteDB) UpdateSubscription(
+	userId int,
+	event SubscriptionEvent,
+	periodEnd time.Time,
+) (User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := updateSubscriptionTx(tx, userId, event, periodEnd)
+	if err != nil {
+		log.Printf("--> DB: Could not apply %s to user %d: %s", event, userId, err)
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+
+	log.Printf("--> DB: user %d subscription is %s", userId, user.Subscription.Status)
+	return user, nil
+}
+
+// updateSubscriptionTx applies event to the user's subscription within tx
+func updateSubscriptionTx(
+	tx *sql.Tx,
+	userId int,
+	event SubscriptionEvent,
+	periodEnd time.Time,
+) (User, error) {
+	user, err := getUserTx(tx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, fmt.Errorf(
+			"Database does not contain User ID: %d: %w",
+			userId,
+			ErrUserNotFound,
+		)
+	}
+	if err != nil {
+		return User{}, err
+	}
+	at := now()
+	sub, err := user.Subscription.next(event, utc(periodEnd), at)
+	if err != nil {
+		return User{}, err
+	}
+	_, err = tx.Exec(
+		`UPDATE users SET subscription_status = ?, subscription_period_end = ?,
+			updated_at = ?
+		 WHERE id = ?`,
+		sub.Status,
+		nullTime(sub.PeriodEnd),
+		at,
+		userId,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	return getUserTx(tx, userId)
+}
+
+func (s *SQLiteDB) UpgradeUser(userId int) error {
+	_, err := s.UpdateSubscription(userId, EventUpgraded, time.Time{})
+	return err
+}
+
+func (s *SQLiteDB) ExpireSubscriptions() (int, error) {
+	at := now()
+	res, err := s.db.Exec(
+		`UPDATE users SET subscription_status = ?, updated_at = ?
+		 WHERE subscription_status IN (?, ?, ?)
+		 AND subscription_period_end IS NOT NULL
+		 AND subscription_period_end <= ?`,
+		SubscriptionExpired,
+		at,
+		SubscriptionActive,
+		SubscriptionPastDue,
+		SubscriptionCancelled,
+		at,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func (s *SQLiteDB) SetUserRole(userId int, role Role) (User, error) {
+	if !role.Valid() {
+		return User{}, ErrInvalidRole
+	}
+
+	res, err := s.db.Exec(
+		"UPDATE users SET role = ?, updated_at = ? WHERE id = ?",
+		role,
+		now(),
+		userId,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+	if n == 0 {
+		return User{}, errors.New(
+			fmt.Sprintf("Database does not contain User ID: %d", userId),
+		)
+	}
+
+	return s.GetUser(userId)
+}
+
+// setProfileTx replaces the user's profile within tx
+func setProfileTx(tx *sql.Tx, userId int, profile Profile, at time.Time) error {
+	if profile.Handle != "" {
+		var id int
+		err := tx.QueryRow("SELECT id FROM users WHERE handle = ?", profile.Handle).Scan(&id)
+		if err == nil && id != userId {
+			return ErrHandleTaken
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	res, err := tx.Exec(
+		`UPDATE users SET handle = ?, display_name = ?, bio = ?, avatar = ?,
+			updated_at = ?
+		 WHERE id = ?`,
+		profile.Handle,
+		profile.DisplayName,
+		profile.Bio,
+		profile.Avatar,
+		at,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New(
+			fmt.Sprintf("Database does not contain User ID: %d", userId),
+		)
+	}
+	return nil
+}
+
+func (s *SQLiteDB) GetUserByHandle(handle string) (User, error) {
+	if handle == "" {
+		return User{}, ErrHandleNotFound
+	}
+	user, err := scanUser(s.db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE handle = ?",
+		handle,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrHandleNotFound
+	}
+	return user, err
+}
+
+func (s *SQLiteDB) GetUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *SQLiteDB) GetUser(id int) (User, error) {
+	user, err := scanUser(s.db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE id = ?",
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New(
+			fmt.Sprintf("Database does not contain User ID: %d", id),
+		)
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteDB) GetUserId(email string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("Could not get userID")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *SQLiteDB) GetUserDetails(
+	userId int,
+) (email, hashedPW string, isChirpyRed bool, err error) {
+	user, err := scanUser(
+		s.db.QueryRow(
+			"SELECT "+userColumns+", hashed_password FROM users WHERE id = ?",
+			userId,
+		),
+		&hashedPW,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", false, errors.New("Could not get userID")
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	return user.Email, hashedPW, user.IsChirpyRed, nil
+}
+
+func (s *SQLiteDB) GetUserPassword(id int) (string, error) {
+	_, hash, _, err := s.GetUserDetails(id)
+	return hash, err
+}
+
+func (s *SQLiteDB) AuthenticateUser(email string, password string) (User, error) {
+	var hash string
+	user, err := scanUser(s.db.QueryRow(
+		"SELECT "+userColumns+", hashed_password FROM users WHERE email = ?",
+		email,
+	), &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New("Could not get userID")
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+const refreshTokenColumns = `hash, user_id, family_id, created_at,
+	expires_at, rotated_at, revoked_at`
+
+func scanRefreshToken(sc scanner) (RefreshToken, error) {
+	rt := RefreshToken{}
+	var rotatedAt, revokedAt sql.NullTime
+	err := sc.Scan(
+		&rt.Hash,
+		&rt.UserID,
+		&rt.FamilyID,
+		&rt.CreatedAt,
+		&rt.ExpiresAt,
+		&rotatedAt,
+		&revokedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RefreshToken{}, ErrTokenInvalid
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	rt.CreatedAt = rt.CreatedAt.UTC()
+	rt.ExpiresAt = rt.ExpiresAt.UTC()
+	if rotatedAt.Valid {
+		rt.RotatedAt = rotatedAt.Time.UTC()
+	}
+	if revokedAt.Valid {
+		rt.RevokedAt = revokedAt.Time.UTC()
+	}
+	return rt, nil
+}
+
+// execer is the Exec method shared by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertRefreshToken stores rt and marks its session used
+func insertRefreshToken(ex execer, rt RefreshToken) error {
+	_, err := ex.Exec(
+		"UPDATE sessions SET last_used_at = ?, expires_at = ? WHERE id = ?",
+		rt.CreatedAt,
+		rt.ExpiresAt,
+		rt.FamilyID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(
+		`INSERT INTO refresh_tokens (hash, user_id, family_id, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		rt.Hash,
+		rt.UserID,
+		rt.FamilyID,
+		rt.CreatedAt,
+		rt.ExpiresAt,
+	)
+	return err
+}
+
+// revokeFamilySQL revokes a session and every refresh token issued for it
+func revokeFamilySQL(ex execer, familyID string, at time.Time) error {
+	_, err := ex.Exec(
+		`UPDATE refresh_tokens SET revoked_at = ?
+		 WHERE family_id = ? AND revoked_at IS NULL`,
+		at,
+		familyID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(
+		"UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		at,
+		familyID,
+	)
+	return err
+}
+
+func (s *SQLiteDB) RotateRefreshToken(
+	old, next string,
+	expiresAt time.Time,
+) (RefreshToken, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	at := now()
+	cur, err := scanRefreshToken(tx.QueryRow(
+		"SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE hash = ?",
+		hashToken(old),
+	))
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if !cur.RotatedAt.IsZero() && cur.RevokedAt.IsZero() {
+		if err := revokeFamilySQL(tx, cur.FamilyID, at); err != nil {
+			return RefreshToken{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return RefreshToken{}, err
+		}
+		return cur, ErrTokenReused
+	}
+	if !cur.usable(at) {
+		return RefreshToken{}, ErrTokenInvalid
+	}
+
+	_, err = tx.Exec(
+		"UPDATE refresh_tokens SET rotated_at = ? WHERE hash = ?",
+		at,
+		cur.Hash,
+	)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	rt := RefreshToken{
+		Hash:      hashToken(next),
+		UserID:    cur.UserID,
+		FamilyID:  cur.FamilyID,
+		CreatedAt: at,
+		ExpiresAt: expiresAt.UTC(),
+	}
+	if err := insertRefreshToken(tx, rt); err != nil {
+		return RefreshToken{}, err
+	}
+	return rt, tx.Commit()
+}
+
+func (s *SQLiteDB) RevokeRefreshToken(token string) error {
+	rt, err := s.GetRefreshToken(token)
+	if errors.Is(err, ErrTokenInvalid) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return revokeFamilySQL(s.db, rt.FamilyID, now())
+}
+
+func (s *SQLiteDB) PruneRefreshTokens() (int, error) {
+	res, err := s.db.Exec("DELETE FROM refresh_tokens WHERE expires_at <= ?", now())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLiteDB) GetRefreshToken(token string) (RefreshToken, error) {
+	return scanRefreshToken(s.db.QueryRow(
+		"SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE hash = ?",
+		hashToken(token),
+	))
+}
+
+const sessionColumns = `id, user_id, user_agent, ip, created_at,
+	last_used_at, expires_at, revoked_at`
+
+func scanSession(sc scanner) (Session, error) {
+	session := Session{}
+	var revokedAt sql.NullTime
+	err := sc.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&revokedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.LastUsedAt = session.LastUsedAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	if revokedAt.Valid {
+		session.RevokedAt = revokedAt.Time.UTC()
+	}
+	return session, nil
+}
+
+func (s *SQLiteDB) CreateSession(
+	userID int,
+	userAgent, ip, token string,
+	expiresAt time.Time,
+) (Session, error) {
+	if _, err := s.GetUser(userID); err != nil {
+		return Session{}, errors.New("Could not get userID")
+	}
+	id, err := newSessionID()
+	if err != nil {
+		return Session{}, err
+	}
+
+	at := now()
+	session := Session{
+		ID:         id,
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  at,
+		LastUsedAt: at,
+		ExpiresAt:  expiresAt.UTC(),
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Session{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO sessions
+		 (id, user_id, user_agent, ip, created_at, last_used_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.CreatedAt,
+		session.LastUsedAt,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return Session{}, err
+	}
+	err = insertRefreshToken(tx, RefreshToken{
+		Hash:      hashToken(token),
+		UserID:    userID,
+		FamilyID:  id,
+		CreatedAt: at,
+		ExpiresAt: session.ExpiresAt,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return session, tx.Commit()
+}
+
+func (s *SQLiteDB) GetSession(id string) (Session, error) {
+	return scanSession(s.db.QueryRow(
+		"SELECT "+sessionColumns+" FROM sessions WHERE id = ?",
+		id,
+	))
+}
+
+func (s *SQLiteDB) GetSessions(userID int) ([]Session, error) {
+	rows, err := s.db.Query(
+		`SELECT `+sessionColumns+` FROM sessions
+		 WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?`,
+		userID,
+		now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *SQLiteDB) RevokeSession(userID int, id string) error {
+	session, err := s.GetSession(id)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID || !session.Active(now()) {
+		return ErrSessionNotFound
+	}
+	return revokeFamilySQL(s.db, id, now())
+}
+
+func (s *SQLiteDB) RevokeSessions(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeSessionsSQL(tx, userID, "", now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// revokeSessionsSQL ends userID's sessions other than keep, which may be
+// empty
+func revokeSessionsSQL(ex execer, userID int, keep string, at time.Time) error {
+	_, err := ex.Exec(
+		`UPDATE refresh_tokens SET revoked_at = ?
+		 WHERE user_id = ? AND family_id != ? AND revoked_at IS NULL`,
+		at,
+		userID,
+		keep,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(
+		`UPDATE sessions SET revoked_at = ?
+		 WHERE user_id = ? AND id != ? AND revoked_at IS NULL`,
+		at,
+		userID,
+		keep,
+	)
+	return err
+}
+
+func (s *SQLiteDB) CreatePasswordReset(
+	userID int,
+	token string,
+	expiresAt time.Time,
+) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getUserTx(tx, userID); err != nil {
+		return errors.New("Could not get userID")
+	}
+	at := now()
+	_, err = tx.Exec(
+		"DELETE FROM password_resets WHERE user_id = ? OR expires_at <= ? OR used_at IS NOT NULL",
+		userID,
+		at,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO password_resets (hash, user_id, created_at, expires_at)
+		 VALUES (?, ?, ?, ?)`,
+		hashToken(token),
+		userID,
+		at,
+		expiresAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDB) ResetPassword(token, passwd string) (User, error) {
+	pw, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	at := now()
+	var userID int
+	err = tx.QueryRow(
+		`SELECT user_id FROM password_resets
+		 WHERE hash = ? AND used_at IS NULL AND expires_at > ?`,
+		hashToken(token),
+		at,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrResetInvalid
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE password_resets SET used_at = ? WHERE hash = ?",
+		at,
+		hashToken(token),
+	)
+	if err != nil {
+		return User{}, err
+	}
+	_, err = tx.Exec(
+		"UPDATE users SET hashed_password = ?, updated_at = ? WHERE id = ?",
+		string(pw),
+		at,
+		userID,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	if err := revokeSessionsSQL(tx, userID, "", at); err != nil {
+		return User{}, err
+	}
+	user, err := getUserTx(tx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// getUserTx is GetUser within tx
+func getUserTx(tx *sql.Tx, id int) (User, error) {
+	return scanUser(tx.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE id = ?",
+		id,
+	))
+}
+
+func (s *SQLiteDB) CreateEmailVerification(
+	userID int,
+	email, token string,
+	expiresAt time.Time,
+) (User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := getUserTx(tx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.New(
+			fmt.Sprintf("Database does not contain User ID: %d", userID),
+		)
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if err := pendEmailTx(tx, user, email, token, expiresAt, now()); err != nil {
+		return User{}, err
+	}
+
+	user, err = getUserTx(tx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// pendEmailTx makes email user's pending address, unless it is already
+// theirs, and stores the verification for token that confirms it
+func pendEmailTx(
+	tx *sql.Tx,
+	user User,
+	email, token string,
+	expiresAt, at time.Time,
+) error {
+	if err := emailFree(tx, email, user.Id); err != nil {
+		return err
+	}
+
+	var err error
+	if email == user.Email {
+		_, err = tx.Exec("UPDATE users SET pending_email = '' WHERE id = ?", user.Id)
+	} else {
+		_, err = tx.Exec(
+			"UPDATE users SET pending_email = ?, updated_at = ? WHERE id = ?",
+			email,
+			at,
+			user.Id,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM email_verifications
+		 WHERE user_id = ? OR expires_at <= ? OR used_at IS NOT NULL`,
+		user.Id,
+		at,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO email_verifications
+			(hash, user_id, email, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		hashToken(token),
+		user.Id,
+		email,
+		at,
+		expiresAt.UTC(),
+	)
+	return err
+}
+
+func (s *SQLiteDB) VerifyEmail(token string) (User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	at := now()
+	var userID int
+	var email string
+	err = tx.QueryRow(
+		`SELECT user_id, email FROM email_verifications
+		 WHERE hash = ? AND used_at IS NULL AND expires_at > ?`,
+		hashToken(token),
+		at,
+	).Scan(&userID, &email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrVerificationInvalid
+	}
+	if err != nil {
+		return User{}, err
+	}
+	user, err := getUserTx(tx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrVerificationInvalid
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	switch email {
+	case user.Email:
+	case user.PendingEmail:
+		if err := emailFree(tx, email, userID); err != nil {
+			return User{}, err
+		}
+	default:
+		// the user has since changed address
+		return User{}, ErrVerificationInvalid
+	}
+
+	_, err = tx.Exec(
+		"UPDATE email_verifications SET used_at = ? WHERE hash = ?",
+		at,
+		hashToken(token),
+	)
+	if err != nil {
+		return User{}, err
+	}
+	_, err = tx.Exec(
+		`UPDATE users SET email = ?, pending_email = '', verified = 1, updated_at = ?
+		 WHERE id = ?`,
+		email,
+		at,
+		userID,
+	)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err = getUserTx(tx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// emailFree returns ErrEmailTaken if someone other than userID is
+// registered with email
+func emailFree(tx *sql.Tx, email string, userID int) error {
+	var id int
+	err := tx.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if id != userID {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+func (s *SQLiteDB) StartTwoFactor(userID int, secret string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getUserTx(tx, userID); err != nil {
+		return errors.New(
+			fmt.Sprintf("Database does not contain User ID: %d", userID),
+		)
+	}
+	var enabled bool
+	err = tx.QueryRow(
+		"SELECT enabled FROM two_factor WHERE user_id = ?",
+		userID,
+	).Scan(&enabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if enabled {
+		return ErrTwoFactorEnabled
+	}
+
+	at := now()
+	_, err = tx.Exec(
+		`INSERT INTO two_factor (user_id, secret, created_at, updated_at)
+		 VALUES (?1, ?2, ?3, ?3)
+		 ON CONFLICT (user_id) DO UPDATE SET
+			secret = ?2, last_step = 0, created_at = ?3, updated_at = ?3`,
+		userID,
+		secret,
+		at,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDB) EnableTwoFactor(
+	userID int,
+	step int64,
+	recoveryCodes []string,
+) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE two_factor SET enabled = 1, last_step = ?, updated_at = ?
+		 WHERE user_id = ?`,
+		step,
+		now(),
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return cmp.Or(err, ErrTwoFactorNotFound)
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ex execer, userID int, hashes []string) error {
+	_, err := ex.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := ex.Exec(
+			"INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?)",
+			userID,
+			hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteDB) DisableTwoFactor(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM two_factor WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return cmp.Or(err, ErrTwoFactorNotFound)
+	}
+	if err := replaceRecoveryCodes(tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDB) GetTwoFactor(userID int) (TwoFactor, error) {
+	tf := TwoFactor{UserID: userID, RecoveryCodes: []string{}}
+	err := s.db.QueryRow(
+		`SELECT secret, enabled, last_step, created_at, updated_at
+		 FROM two_factor WHERE user_id = ?`,
+		userID,
+	).Scan(&tf.Secret, &tf.Enabled, &tf.LastStep, &tf.CreatedAt, &tf.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TwoFactor{}, ErrTwoFactorNotFound
+	}
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	tf.CreatedAt = tf.CreatedAt.UTC()
+	tf.UpdatedAt = tf.UpdatedAt.UTC()
+
+	rows, err := s.db.Query(
+		"SELECT hash FROM recovery_codes WHERE user_id = ? ORDER BY hash",
+		userID,
+	)
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return TwoFactor{}, err
+		}
+		tf.RecoveryCodes = append(tf.RecoveryCodes, hash)
+	}
+	return tf, rows.Err()
+}
+
+func (s *SQLiteDB) UseTOTPStep(userID int, step int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var last int64
+	err = tx.QueryRow(
+		"SELECT last_step FROM two_factor WHERE user_id = ?",
+		userID,
+	).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTwoFactorNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if step <= last {
+		return ErrCodeReused
+	}
+	_, err = tx.Exec(
+		"UPDATE two_factor SET last_step = ?, updated_at = ? WHERE user_id = ?",
+		step,
+		now(),
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDB) UseRecoveryCode(userID int, code string) error {
+	res, err := s.db.Exec(
+		`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?
+		 AND EXISTS (SELECT 1 FROM two_factor WHERE user_id = ? AND enabled = 1)`,
+		userID,
+		HashRecoveryCode(code),
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+const loginThrottleColumns = "key, failures, last_failure, locked_until"
+
+func scanLoginThrottle(sc scanner) (LoginThrottle, error) {
+	lt := LoginThrottle{}
+	var lockedUntil sql.NullTime
+	err := sc.Scan(&lt.Key, &lt.Failures, &lt.LastFailure, &lockedUntil)
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	lt.LastFailure = lt.LastFailure.UTC()
+	if lockedUntil.Valid {
+		lt.LockedUntil = lockedUntil.Time.UTC()
+	}
+	return lt, nil
+}
+
+func (s *SQLiteDB) RecordLoginFailure(
+	key string,
+	policy LockoutPolicy,
+) (LoginThrottle, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	defer tx.Rollback()
+
+	at := now()
+	old, err := scanLoginThrottle(tx.QueryRow(
+		"SELECT "+loginThrottleColumns+" FROM login_throttles WHERE key = ?",
+		key,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		old = LoginThrottle{Key: key}
+	} else if err != nil {
+		return LoginThrottle{}, err
+	}
+	lt := policy.fail(old, at)
+
+	var lockedUntil sql.NullTime
+	if !lt.LockedUntil.IsZero() {
+		lockedUntil = sql.NullTime{Time: lt.LockedUntil, Valid: true}
+	}
+	_, err = tx.Exec(
+		`INSERT INTO login_throttles (key, failures, last_failure, locked_until)
+		 VALUES (?1, ?2, ?3, ?4)
+		 ON CONFLICT (key) DO UPDATE SET
+			failures = ?2, last_failure = ?3, locked_until = ?4`,
+		lt.Key,
+		lt.Failures,
+		lt.LastFailure,
+		lockedUntil,
+	)
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	return lt, tx.Commit()
+}
+
+func (s *SQLiteDB) GetLoginThrottle(key string) (LoginThrottle, error) {
+	lt, err := scanLoginThrottle(s.db.QueryRow(
+		"SELECT "+loginThrottleColumns+" FROM login_throttles WHERE key = ?",
+		key,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginThrottle{Key: key}, nil
+	}
+	return lt, err
+}
+
+func (s *SQLiteDB) ClearLoginFailures(key string) error {
+	_, err := s.db.Exec("DELETE FROM login_throttles WHERE key = ?", key)
+	return err
+}
+
+func (s *SQLiteDB) PruneLoginThrottles() (int, error) {
+	at := now()
+	res, err := s.db.Exec(
+		`DELETE FROM login_throttles WHERE last_failure < ?
+		 AND (locked_until IS NULL OR locked_until <= ?)`,
+		at.Add(-throttleRetention),
+		at,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+const webhookEventColumns = "id, event, payload, processed_at"
+
+func scanWebhookEvent(sc scanner) (WebhookEvent, error) {
+	ev := WebhookEvent{}
+	err := sc.Scan(&ev.ID, &ev.Event, &ev.Payload, &ev.ProcessedAt)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	ev.ProcessedAt = ev.ProcessedAt.UTC()
+	return ev, nil
+}
+
+func (s *SQLiteDB) ProcessWebhookEvent(
+	ev WebhookEvent,
+	change *SubscriptionChange,
+) (WebhookEvent, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	defer tx.Rollback()
+
+	// claiming the ID first means a redelivery racing this one finds it
+	// taken and changes nothing
+	ev.ProcessedAt = now()
+	res, err := tx.Exec(
+		`INSERT INTO webhook_events (id, event, payload, processed_at)
+		 VALUES (?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		ev.ID,
+		ev.Event,
+		ev.Payload,
+		ev.ProcessedAt,
+	)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	if n == 0 {
+		return WebhookEvent{}, ErrWebhookEventSeen
+	}
+
+	var changeErr error
+	if change != nil {
+		_, err := updateSubscriptionTx(tx, change.UserID, change.Event, change.PeriodEnd)
+		if errors.Is(err, ErrInvalidTransition) {
+			changeErr = err
+		} else if err != nil {
+			return WebhookEvent{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return WebhookEvent{}, err
+	}
+	return ev, changeErr
+}
+
+func (s *SQLiteDB) GetWebhookEvent(id string) (WebhookEvent, error) {
+	ev, err := scanWebhookEvent(s.db.QueryRow(
+		"SELECT "+webhookEventColumns+" FROM webhook_events WHERE id = ?",
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookEvent{}, ErrWebhookEventNotFound
+	}
+	return ev, err
+}
+
+func (s *SQLiteDB) DeleteChirpsByAuthor(authorId int) (int, error) {
+	res, err := s.db.Exec("DELETE FROM chirps WHERE author_id = ?", authorId)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLiteDB) AnonymizeChirps(authorId int) (int, error) {
+	res, err := s.db.Exec(
+		"UPDATE chirps SET author_id = ?, updated_at = ? WHERE author_id = ?",
+		AnonymousAuthor,
+		now(),
+		authorId,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLiteDB) DeleteUser(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New(
+			fmt.Sprintf("Database does not contain User ID: %d", id),
+		)
+	}
+	for _, table := range []string{
+		"sessions",
+		"refresh_tokens",
+		"password_resets",
+		"email_verifications",
+		"two_factor",
+		"recovery_codes",
+	} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", id)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("--> DB: deleted user %d", id)
+	return nil
+}
+
+func (s *SQLiteDB) SessionHistory(userID int) ([]Session, error) {
+	rows, err := s.db.Query(
+		"SELECT "+sessionColumns+" FROM sessions WHERE user_id = ?",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
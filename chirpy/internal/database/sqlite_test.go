@@ -0,0 +1,140 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func setupSQLite(t *testing.T) *SQLiteDB {
+	t.Helper()
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "chirpy.sqlite3"))
+	if err != nil {
+		t.Fatalf("couldn't open sqlite db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteDB_migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.sqlite3")
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("first open: %s", err)
+	}
+	if _, err := db.StoreChirp("survives reopen", 1); err != nil {
+		t.Fatalf("StoreChirp: %s", err)
+	}
+	db.Close()
+
+	// reopening must not re-run migrations or lose data
+	db, err = NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("second open: %s", err)
+	}
+	defer db.Close()
+
+	var versions int
+	err = db.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions != len(sqliteMigrations) {
+		t.Errorf("schema_migrations has %d rows, want %d", versions, len(sqliteMigrations))
+	}
+	if _, err := db.GetChirp(1); err != nil {
+		t.Errorf("GetChirp(1) after reopen: %s", err)
+	}
+
+	// a schema from a newer binary must be refused
+	_, err = db.db.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'future', CURRENT_TIMESTAMP)",
+		len(sqliteMigrations)+100,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db.db, sqliteMigrations); err == nil {
+		t.Error("migrate() accepted a newer schema version")
+	}
+}
+
+func TestSQLiteDB_Chirps(t *testing.T) {
+	stopClock(t)
+	db := setupSQLite(t)
+
+	chirps := []Chirp{
+		{Id: 1, Body: "This is the first one", AuthorId: 1, CreatedAt: testTime, UpdatedAt: testTime},
+		{Id: 2, Body: "This is the second one", AuthorId: 2, CreatedAt: testTime, UpdatedAt: testTime},
+		{Id: 3, Body: "This is the third one", AuthorId: 1, CreatedAt: testTime, UpdatedAt: testTime},
+	}
+	for _, ch := range chirps {
+		got, err := db.StoreChirp(ch.Body, ch.AuthorId)
+		if err != nil {
+			t.Fatalf("SQLiteDB.StoreChirp() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, ch) {
+			t.Errorf("SQLiteDB.StoreChirp() = %v, want %v", got, ch)
+		}
+	}
+
+	got, err := db.ChirpsByAuthorID(1, true)
+	if err != nil {
+		t.Fatalf("SQLiteDB.ChirpsByAuthorID() error = %v", err)
+	}
+	want := []Chirp{chirps[2], chirps[0]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SQLiteDB.ChirpsByAuthorID() = %v, want %v", got, want)
+	}
+
+	if err := db.DeleteChirp(2); err != nil {
+		t.Fatalf("SQLiteDB.DeleteChirp() error = %v", err)
+	}
+	if _, err := db.GetChirp(2); err == nil {
+		t.Error("SQLiteDB.GetChirp() found a deleted chirp")
+	}
+}
+
+func TestSQLiteDB_Users(t *testing.T) {
+	stopClock(t)
+	db := setupSQLite(t)
+
+	user, err := db.AddUser("walt@breakingbad.com", "123456")
+	if err != nil {
+		t.Fatalf("SQLiteDB.AddUser() error = %v", err)
+	}
+	if _, err := db.AddUser("walt@breakingbad.com", "654321"); err == nil {
+		t.Error("SQLiteDB.AddUser() registered a duplicate email")
+	}
+
+	if _, err := db.AuthenticateUser("walt@breakingbad.com", "wrong"); err == nil {
+		t.Error("SQLiteDB.AuthenticateUser() accepted a wrong password")
+	}
+	if err := db.UpgradeUser(user.Id); err != nil {
+		t.Fatalf("SQLiteDB.UpgradeUser() error = %v", err)
+	}
+	got, err := db.AuthenticateUser("walt@breakingbad.com", "123456")
+	if err != nil {
+		t.Fatalf("SQLiteDB.AuthenticateUser() error = %v", err)
+	}
+	want := User{
+		Id:           user.Id,
+		Email:        "walt@breakingbad.com",
+		IsChirpyRed:  true,
+		Subscription: Subscription{Status: SubscriptionActive},
+		Role:         RoleUser,
+		CreatedAt:    testTime,
+		UpdatedAt:    testTime,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SQLiteDB.AuthenticateUser() = %v, want %v", got, want)
+	}
+
+	if _, err := db.CreateSession(user.Id, "", "", "tkn", testTime.Add(time.Hour)); err != nil {
+		t.Fatalf("SQLiteDB.CreateSession() error = %v", err)
+	}
+	if rt, err := db.GetRefreshToken("tkn"); err != nil || rt.Hash == "tkn" {
+		t.Errorf("SQLiteDB.GetRefreshToken() = %+v, %v; want a hashed token", rt, err)
+	}
+}
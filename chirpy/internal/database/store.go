@@ -0,0 +1,73 @@
+package database
+
+import "time"
+
+// ChirpStore is the set of operations the API needs from a chirp backend.
+// The JSON file store (DB) is one implementation.
+type ChirpStore interface {
+	StoreChirp(body string, authorId int) (Chirp, error)
+	DeleteChirp(chirpId int) error
+	DeleteChirpsByAuthor(authorId int) (int, error)
+	AnonymizeChirps(authorId int) (int, error)
+	GetChirps(desc bool) ([]Chirp, error)
+	GetChirp(id int) (Chirp, error)
+	ChirpsByAuthorID(authorId int, desc bool) ([]Chirp, error)
+	ChirpsPage(q ChirpQuery) (ChirpPage, error)
+}
+
+// UserStore is the set of operations the API needs from a user backend.
+// The JSON file store (UserDB) is one implementation.
+type UserStore interface {
+	AddUser(email string, passwd string) (User, error)
+	ChangeAccount(userId int, change AccountChange) (User, error)
+	DeleteUser(id int) error
+	UpgradeUser(userId int) error
+	UpdateSubscription(userId int, event SubscriptionEvent, periodEnd time.Time) (User, error)
+	ExpireSubscriptions() (int, error)
+	SetUserRole(userId int, role Role) (User, error)
+	GetUserByHandle(handle string) (User, error)
+	GetUsers() ([]User, error)
+	GetUser(id int) (User, error)
+	GetUserId(email string) (int, error)
+	GetUserDetails(userId int) (email, hashedPW string, isChirpyRed bool, err error)
+	GetUserPassword(id int) (string, error)
+	AuthenticateUser(email string, password string) (User, error)
+	RotateRefreshToken(old, next string, expiresAt time.Time) (RefreshToken, error)
+	RevokeRefreshToken(token string) error
+	GetRefreshToken(token string) (RefreshToken, error)
+	PruneRefreshTokens() (int, error)
+	CreateSession(userID int, userAgent, ip, token string, expiresAt time.Time) (Session, error)
+	GetSession(id string) (Session, error)
+	GetSessions(userID int) ([]Session, error)
+	SessionHistory(userID int) ([]Session, error)
+	RevokeSession(userID int, id string) error
+	RevokeSessions(userID int) error
+	CreatePasswordReset(userID int, token string, expiresAt time.Time) error
+	ResetPassword(token, passwd string) (User, error)
+	CreateEmailVerification(userID int, email, token string, expiresAt time.Time) (User, error)
+	VerifyEmail(token string) (User, error)
+	StartTwoFactor(userID int, secret string) error
+	EnableTwoFactor(userID int, step int64, recoveryCodes []string) error
+	DisableTwoFactor(userID int) error
+	GetTwoFactor(userID int) (TwoFactor, error)
+	UseTOTPStep(userID int, step int64) error
+	UseRecoveryCode(userID int, code string) error
+	RecordLoginFailure(key string, policy LockoutPolicy) (LoginThrottle, error)
+	GetLoginThrottle(key string) (LoginThrottle, error)
+	ClearLoginFailures(key string) error
+	PruneLoginThrottles() (int, error)
+	ProcessWebhookEvent(ev WebhookEvent, change *SubscriptionChange) (WebhookEvent, error)
+	GetWebhookEvent(id string) (WebhookEvent, error)
+}
+
+var (
+	_ ChirpStore = (*DB)(nil)
+	_ UserStore  = (*UserDB)(nil)
+)
+
+// now stamps created_at and updated_at on records. Times are kept in UTC
+// so they compare and round-trip through every store the same way;
+// tests replace it to get predictable values.
+var now = func() time.Time {
+	return time.Now().UTC()
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SubscriptionStatus is where a user's Chirpy Red subscription is in its
+// lifecycle. Users who never subscribed have none, the empty status.
+type SubscriptionStatus string
+
+const (
+	SubscriptionNone      SubscriptionStatus = ""
+	SubscriptionActive    SubscriptionStatus = "active"
+	SubscriptionPastDue   SubscriptionStatus = "past_due"
+	SubscriptionCancelled SubscriptionStatus = "cancelled"
+	SubscriptionExpired   SubscriptionStatus = "expired"
+)
+
+// Subscription is a user's Chirpy Red subscription. PeriodEnd is when
+// the time paid for runs out; subscriptions without one, such as those
+// from before billing periods were tracked, run until they are ended.
+type Subscription struct {
+	Status    SubscriptionStatus `json:"status"`
+	PeriodEnd time.Time          `json:"period_end"`
+}
+
+// Red reports whether s gives the user Chirpy Red at time at. A payment
+// failing doesn't take it away straight away, and nor does cancelling:
+// the user keeps what they paid for until the period ends.
+func (s Subscription) Red(at time.Time) bool {
+	switch s.Status {
+	case SubscriptionActive, SubscriptionPastDue:
+		return s.PeriodEnd.IsZero() || at.Before(s.PeriodEnd)
+	case SubscriptionCancelled:
+		return at.Before(s.PeriodEnd)
+	}
+	return false
+}
+
+// SubscriptionEvent is something the billing provider tells us happened
+// to a subscription
+type SubscriptionEvent string
+
+const (
+	EventUpgraded      SubscriptionEvent = "user.upgraded"
+	EventDowngraded    SubscriptionEvent = "user.downgraded"
+	EventCancelled     SubscriptionEvent = "subscription.cancelled"
+	EventPaymentFailed SubscriptionEvent = "payment.failed"
+)
+
+var (
+	// ErrInvalidTransition is returned for an event that doesn't apply to
+	// a subscription in its current state, such as cancelling an expired
+	// one
+	ErrInvalidTransition = errors.New("invalid subscription transition")
+	// ErrUserNotFound is returned for a subscription change to a user
+	// who doesn't exist
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// Valid reports whether e is one of the known events
+func (e SubscriptionEvent) Valid() bool {
+	switch e {
+	case EventUpgraded, EventDowngraded, EventCancelled, EventPaymentFailed:
+		return true
+	}
+	return false
+}
+
+// next returns s after event happened at time at. A non-zero periodEnd
+// replaces the period end the subscription had.
+func (s Subscription) next(
+	event SubscriptionEvent,
+	periodEnd, at time.Time,
+) (Subscription, error) {
+	from := s.Status
+	switch {
+	case event == EventUpgraded:
+		// subscribing, renewing or resubscribing all start a new period
+		return Subscription{Status: SubscriptionActive, PeriodEnd: periodEnd}, nil
+	case event == EventPaymentFailed &&
+		(from == SubscriptionActive || from == SubscriptionPastDue):
+		s.Status = SubscriptionPastDue
+	case event == EventCancelled &&
+		(from == SubscriptionActive || from == SubscriptionPastDue):
+		s.Status = SubscriptionCancelled
+	case event == EventDowngraded &&
+		(from == SubscriptionActive || from == SubscriptionPastDue ||
+			from == SubscriptionCancelled):
+		return Subscription{Status: SubscriptionExpired, PeriodEnd: at}, nil
+	default:
+		return s, fmt.Errorf("%w: %s from %q", ErrInvalidTransition, event, from)
+	}
+	if !periodEnd.IsZero() {
+		s.PeriodEnd = periodEnd
+	}
+	return s, nil
+}
+
+// lapsed reports whether s has run past its period end at time at and
+// should be expired
+func (s Subscription) lapsed(at time.Time) bool {
+	switch s.Status {
+	case SubscriptionActive, SubscriptionPastDue, SubscriptionCancelled:
+		return !s.PeriodEnd.IsZero() && !at.Before(s.PeriodEnd)
+	}
+	return false
+}
+
+// UpdateSubscription applies event to the user's subscription. periodEnd
+// is the end of the period the event is for, if it names one.
+func (db *UserDB) UpdateSubscription(
+	userId int,
+	event SubscriptionEvent,
+	periodEnd time.Time,
+) (User, error) {
+	user := RegisteredUser{}
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		var err error
+		user, err = changeSubscription(dbStruct, userId, event, periodEnd)
+		if err != nil {
+			return nil, err
+		}
+		return []userRecord{{Op: opSubscriptionChanged, User: &user}}, nil
+	})
+	if err != nil {
+		log.Printf("--> DB: Could not apply %s to user %d: %s", event, userId, err)
+		return User{}, err
+	}
+
+	log.Printf("--> DB: user %d subscription is %s", userId, user.Subscription.Status)
+	return user.toUser(), nil
+}
+
+// changeSubscription returns the user with event applied to their
+// subscription, without storing it
+func changeSubscription(
+	dbStruct *UserDBStructure,
+	userId int,
+	event SubscriptionEvent,
+	periodEnd time.Time,
+) (RegisteredUser, error) {
+	user, ok := dbStruct.Users[userId]
+	if !ok {
+		return RegisteredUser{}, fmt.Errorf(
+			"Database does not contain User ID: %d: %w",
+			userId,
+			ErrUserNotFound,
+		)
+	}
+	at := now()
+	sub, err := user.Subscription.next(event, utc(periodEnd), at)
+	if err != nil {
+		return RegisteredUser{}, err
+	}
+	user.Subscription = sub
+	user.UpdatedAt = at
+	return user, nil
+}
+
+// UpgradeUser gives the user a Chirpy Red subscription with no period end
+func (db *UserDB) UpgradeUser(userId int) error {
+	_, err := db.UpdateSubscription(userId, EventUpgraded, time.Time{})
+	return err
+}
+
+// ExpireSubscriptions expires the subscriptions that have run past their
+// period end and returns how many there were
+func (db *UserDB) ExpireSubscriptions() (int, error) {
+	expired := 0
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		at := now()
+		records := []userRecord{}
+		for _, user := range dbStruct.Users {
+			if !user.Subscription.lapsed(at) {
+				continue
+			}
+			user.Subscription.Status = SubscriptionExpired
+			user.UpdatedAt = at
+			records = append(records, userRecord{Op: opSubscriptionChanged, User: &user})
+		}
+		expired = len(records)
+		return records, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+// utc returns t in UTC, leaving the zero time alone
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC()
+}
+
+// subscribeRedUsers gives users upgraded before subscriptions were
+// tracked a subscription without a period end, and reports whether the
+// file needed it
+func subscribeRedUsers(dbStruct *UserDBStructure) bool {
+	if dbStruct.Version >= 2 {
+		return false
+	}
+	for id, user := range dbStruct.Users {
+		if user.LegacyChirpyRed {
+			user.Subscription = Subscription{Status: SubscriptionActive}
+			user.LegacyChirpyRed = false
+			dbStruct.Users[id] = user
+		}
+	}
+	dbStruct.Version = 2
+	return true
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscription_next(t *testing.T) {
+	at := testTime
+	end := at.Add(30 * 24 * time.Hour)
+	later := end.Add(30 * 24 * time.Hour)
+	active := Subscription{Status: SubscriptionActive, PeriodEnd: end}
+	pastDue := Subscription{Status: SubscriptionPastDue, PeriodEnd: end}
+	cancelled := Subscription{Status: SubscriptionCancelled, PeriodEnd: end}
+	expired := Subscription{Status: SubscriptionExpired, PeriodEnd: at}
+
+	tests := []struct {
+		name      string
+		from      Subscription
+		event     SubscriptionEvent
+		periodEnd time.Time
+		want      Subscription
+		wantErr   bool
+	}{
+		{"subscribe", Subscription{}, EventUpgraded, end, active, false},
+		{"renew", active, EventUpgraded, later, Subscription{SubscriptionActive, later}, false},
+		{"resubscribe", expired, EventUpgraded, end, active, false},
+		{"payment fails", active, EventPaymentFailed, time.Time{}, pastDue, false},
+		{"payment fails again", pastDue, EventPaymentFailed, time.Time{}, pastDue, false},
+		{"payment recovers", pastDue, EventUpgraded, later, Subscription{SubscriptionActive, later}, false},
+		{"cancel", active, EventCancelled, time.Time{}, cancelled, false},
+		{"cancel past due", pastDue, EventCancelled, time.Time{}, cancelled, false},
+		{"downgrade", active, EventDowngraded, time.Time{}, expired, false},
+		{"downgrade cancelled", cancelled, EventDowngraded, time.Time{}, expired, false},
+		{"cancel expired", expired, EventCancelled, time.Time{}, expired, true},
+		{"payment fails when cancelled", cancelled, EventPaymentFailed, time.Time{}, cancelled, true},
+		{"downgrade without subscribing", Subscription{}, EventDowngraded, time.Time{}, Subscription{}, true},
+		{"unknown event", active, "user.exploded", time.Time{}, active, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.from.next(tt.event, tt.periodEnd, at)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("next() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("next() error = %v, want ErrInvalidTransition", err)
+			}
+			if got != tt.want {
+				t.Errorf("next() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscription_Red(t *testing.T) {
+	at := testTime
+	tests := []struct {
+		sub  Subscription
+		want bool
+	}{
+		{Subscription{}, false},
+		{Subscription{SubscriptionActive, time.Time{}}, true},
+		{Subscription{SubscriptionActive, at.Add(time.Hour)}, true},
+		{Subscription{SubscriptionActive, at}, false},
+		{Subscription{SubscriptionPastDue, at.Add(time.Hour)}, true},
+		{Subscription{SubscriptionCancelled, at.Add(time.Hour)}, true},
+		{Subscription{SubscriptionCancelled, time.Time{}}, false},
+		{Subscription{SubscriptionExpired, at.Add(time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.sub.Red(at); got != tt.want {
+			t.Errorf("%+v.Red() = %v, want %v", tt.sub, got, tt.want)
+		}
+	}
+}
+
+func TestUserStore_Subscriptions(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			setClock(t, testTime)
+			walt, _ := store.AddUser("walt@breakingbad.com", "123456")
+			jesse, _ := store.AddUser("jesse@breakingbad.com", "abcdef")
+			end := testTime.Add(24 * time.Hour)
+
+			user, err := store.UpdateSubscription(walt.Id, EventUpgraded, end)
+			if err != nil {
+				t.Fatalf("UpdateSubscription() error = %v", err)
+			}
+			if !user.IsChirpyRed || user.Subscription.Status != SubscriptionActive ||
+				!user.Subscription.PeriodEnd.Equal(end) {
+				t.Errorf("after upgrading = %+v, want active and Red", user)
+			}
+			store.UpdateSubscription(jesse.Id, EventUpgraded, time.Time{})
+			if _, err := store.UpdateSubscription(walt.Id, EventCancelled, time.Time{}); err != nil {
+				t.Fatalf("UpdateSubscription() error = %v", err)
+			}
+			if got, _ := store.GetUser(walt.Id); !got.IsChirpyRed {
+				t.Error("cancelled user lost Chirpy Red before the period ended")
+			}
+			if _, err := store.UpdateSubscription(walt.Id, EventPaymentFailed, time.Time{}); !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("payment failing after cancelling error = %v, want ErrInvalidTransition", err)
+			}
+			if _, err := store.UpdateSubscription(99, EventUpgraded, end); err == nil {
+				t.Error("UpdateSubscription() for an unknown user succeeded")
+			}
+
+			// nothing has lapsed yet
+			if n, err := store.ExpireSubscriptions(); err != nil || n != 0 {
+				t.Errorf("ExpireSubscriptions() = %d, %v; want 0", n, err)
+			}
+			setClock(t, end)
+			if got, _ := store.GetUser(walt.Id); got.IsChirpyRed {
+				t.Error("user is still Red after the period ended")
+			}
+			if n, err := store.ExpireSubscriptions(); err != nil || n != 1 {
+				t.Errorf("ExpireSubscriptions() = %d, %v; want 1", n, err)
+			}
+			got, _ := store.GetUser(walt.Id)
+			if got.Subscription.Status != SubscriptionExpired || !got.UpdatedAt.Equal(end) {
+				t.Errorf("after expiring = %+v, want expired", got)
+			}
+			// subscriptions without a period end run until they are ended
+			if got, _ := store.GetUser(jesse.Id); !got.IsChirpyRed {
+				t.Error("subscription without a period end expired")
+			}
+		})
+	}
+}
+
+func TestUserDB_subscribesRedUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	// a file written before subscriptions
+	os.WriteFile(
+		path,
+		[]byte(`{"version":1,"users":{"1":{"id":1,"email":"old@example.com","password":"x","is_chirpy_red":true}},"addrs":{"old@example.com":1}}`),
+		0600,
+	)
+
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	user, _ := db.GetUser(1)
+	if !user.IsChirpyRed || user.Subscription.Status != SubscriptionActive {
+		t.Errorf("Red user from before subscriptions = %+v, want an active subscription", user)
+	}
+}
+
+func TestSQLiteDB_subscribesRedUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.sqlite3")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(old, sqliteMigrations[:11]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(
+		`INSERT INTO users (email, hashed_password, is_chirpy_red, created_at, updated_at)
+		 VALUES ('old@example.com', 'x', 1, ?, ?), ('free@example.com', 'x', 0, ?, ?)`,
+		testTime,
+		testTime,
+		testTime,
+		testTime,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDB() error = %v", err)
+	}
+	defer db.Close()
+	red, _ := db.GetUser(1)
+	free, _ := db.GetUser(2)
+	if !red.IsChirpyRed || red.Subscription.Status != SubscriptionActive {
+		t.Errorf("Red user from before subscriptions = %+v, want an active subscription", red)
+	}
+	if free.IsChirpyRed || free.Subscription.Status != SubscriptionNone {
+		t.Errorf("free user from before subscriptions = %+v, want no subscription", free)
+	}
+}
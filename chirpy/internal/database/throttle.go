@@ -0,0 +1,138 @@
+package database
+
+import (
+	"time"
+)
+
+// LoginThrottle counts the failed logins for one key, which names either
+// an account or the address the attempts came from
+type LoginThrottle struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"` // zero if never locked
+}
+
+// Locked reports whether logins for the key are refused at time at
+func (lt LoginThrottle) Locked(at time.Time) bool {
+	return at.Before(lt.LockedUntil)
+}
+
+// LockoutPolicy says when repeated failures lock a key out. Once there
+// have been Threshold failures each further one locks the key, for Base
+// at first and twice as long each time after, up to Max. Failures are
+// forgotten once Reset, at most a day, has passed without any.
+type LockoutPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Reset     time.Duration
+}
+
+// lockFor is how long the failures-th failure locks a key for
+func (p LockoutPolicy) lockFor(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	return min(d, p.Max)
+}
+
+// fail counts a failure at time at against lt under policy p
+func (p LockoutPolicy) fail(lt LoginThrottle, at time.Time) LoginThrottle {
+	reset := min(p.Reset, throttleRetention)
+	if at.Sub(lt.LastFailure) >= reset && !lt.Locked(at) {
+		lt.Failures = 0
+	}
+	lt.Failures++
+	lt.LastFailure = at
+	if d := p.lockFor(lt.Failures); d > 0 {
+		lt.LockedUntil = at.Add(d)
+	}
+	return lt
+}
+
+// RecordLoginFailure counts a failed login against key and returns its
+// throttle, locked if the failure took it over the policy's threshold
+func (db *UserDB) RecordLoginFailure(
+	key string,
+	policy LockoutPolicy,
+) (LoginThrottle, error) {
+	lt := LoginThrottle{}
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		old, ok := dbStruct.LoginThrottles[key]
+		if !ok {
+			old = LoginThrottle{Key: key}
+		}
+		lt = policy.fail(old, now())
+		return []userRecord{{Op: opLoginFailed, Throttle: &lt}}, nil
+	})
+	return lt, err
+}
+
+// GetLoginThrottle returns key's throttle, which is empty if no logins
+// have failed for it
+func (db *UserDB) GetLoginThrottle(key string) (LoginThrottle, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	lt, ok := db.data.LoginThrottles[key]
+	if !ok {
+		return LoginThrottle{Key: key}, nil
+	}
+	return lt, nil
+}
+
+// ClearLoginFailures forgets key's failures, unlocking it
+func (db *UserDB) ClearLoginFailures(key string) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		if _, ok := dbStruct.LoginThrottles[key]; !ok {
+			return nil, nil
+		}
+		return []userRecord{{Op: opLoginCleared, Key: key, At: now()}}, nil
+	})
+}
+
+// PruneLoginThrottles drops the throttles that are no longer locked and
+// have had no failures for a day, and returns how many there were. No
+// policy keeps failures longer than that.
+func (db *UserDB) PruneLoginThrottles() (int, error) {
+	pruned := 0
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		at := now()
+		pruned = 0
+		for _, lt := range dbStruct.LoginThrottles {
+			if throttleExpired(lt, at) {
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return nil, nil
+		}
+		return []userRecord{{Op: opLoginPruned, At: at}}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// pruneLoginThrottles drops the throttles that had expired by at
+func pruneLoginThrottles(dbStruct *UserDBStructure, at time.Time) {
+	for key, lt := range dbStruct.LoginThrottles {
+		if throttleExpired(lt, at) {
+			delete(dbStruct.LoginThrottles, key)
+		}
+	}
+}
+
+// throttleExpired reports whether lt can be forgotten at time at
+func throttleExpired(lt LoginThrottle, at time.Time) bool {
+	return !lt.Locked(at) && at.Sub(lt.LastFailure) > throttleRetention
+}
+
+// throttleRetention is how long failures are kept once a key is unlocked
+const throttleRetention = 24 * time.Hour
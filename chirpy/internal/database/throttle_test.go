@@ -0,0 +1,144 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testLockout = LockoutPolicy{
+	Threshold: 3,
+	Base:      time.Minute,
+	Max:       10 * time.Minute,
+	Reset:     time.Hour,
+}
+
+func TestLockoutPolicy_lockFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := testLockout.lockFor(tt.failures); got != tt.want {
+			t.Errorf("lockFor(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestUserStore_LoginThrottles(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			key := "account:walt@breakingbad.com"
+			at := testTime
+			fail := func() LoginThrottle {
+				t.Helper()
+				setClock(t, at)
+				lt, err := store.RecordLoginFailure(key, testLockout)
+				if err != nil {
+					t.Fatalf("RecordLoginFailure() error = %v", err)
+				}
+				return lt
+			}
+
+			if lt, err := store.GetLoginThrottle(key); err != nil || lt.Failures != 0 {
+				t.Errorf("GetLoginThrottle() before failures = %+v, %v", lt, err)
+			}
+			fail()
+			if lt := fail(); lt.Locked(at) {
+				t.Errorf("locked after 2 failures: %+v", lt)
+			}
+			lt := fail()
+			if !lt.Locked(at) || !lt.LockedUntil.Equal(at.Add(time.Minute)) {
+				t.Errorf("after 3 failures = %+v, want locked for a minute", lt)
+			}
+			at = at.Add(time.Minute)
+			if lt := fail(); !lt.LockedUntil.Equal(at.Add(2 * time.Minute)) {
+				t.Errorf("after 4 failures = %+v, want locked for two minutes", lt)
+			}
+
+			got, err := store.GetLoginThrottle(key)
+			if err != nil || got.Failures != 4 || !got.Locked(at) {
+				t.Errorf("GetLoginThrottle() = %+v, %v; want 4 failures, locked", got, err)
+			}
+			if other, _ := store.GetLoginThrottle("ip:192.0.2.1"); other.Failures != 0 {
+				t.Errorf("failures counted against another key: %+v", other)
+			}
+
+			// failures are forgotten after a quiet hour
+			at = at.Add(2 * time.Hour)
+			if lt := fail(); lt.Failures != 1 || lt.Locked(at) {
+				t.Errorf("failure after the reset = %+v, want the count restarted", lt)
+			}
+
+			fail()
+			fail()
+			if err := store.ClearLoginFailures(key); err != nil {
+				t.Fatalf("ClearLoginFailures() error = %v", err)
+			}
+			if lt, _ := store.GetLoginThrottle(key); lt.Failures != 0 || lt.Locked(at) {
+				t.Errorf("GetLoginThrottle() after clearing = %+v", lt)
+			}
+		})
+	}
+}
+
+func TestUserStore_PruneLoginThrottles(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			setClock(t, testTime)
+			store.RecordLoginFailure("ip:192.0.2.1", testLockout)
+			for range testLockout.Threshold {
+				store.RecordLoginFailure("ip:192.0.2.2", LockoutPolicy{
+					Threshold: testLockout.Threshold,
+					Base:      48 * time.Hour,
+					Max:       48 * time.Hour,
+					Reset:     time.Hour,
+				})
+			}
+			setClock(t, testTime.Add(25*time.Hour))
+			store.RecordLoginFailure("ip:192.0.2.3", testLockout)
+
+			// only the quiet, unlocked throttle goes
+			if n, err := store.PruneLoginThrottles(); err != nil || n != 1 {
+				t.Errorf("PruneLoginThrottles() = %d, %v; want 1", n, err)
+			}
+			if lt, _ := store.GetLoginThrottle("ip:192.0.2.1"); lt.Failures != 0 {
+				t.Errorf("expired throttle was kept: %+v", lt)
+			}
+			if lt, _ := store.GetLoginThrottle("ip:192.0.2.2"); lt.Failures == 0 {
+				t.Error("locked throttle was pruned")
+			}
+			if lt, _ := store.GetLoginThrottle("ip:192.0.2.3"); lt.Failures == 0 {
+				t.Error("recent throttle was pruned")
+			}
+		})
+	}
+}
+
+func TestUserDB_LoginThrottlesPersist(t *testing.T) {
+	stopClock(t)
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range testLockout.Threshold {
+		db.RecordLoginFailure("ip:192.0.2.1", testLockout)
+	}
+
+	db, err = NewUserDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lt, _ := db.GetLoginThrottle("ip:192.0.2.1"); !lt.Locked(testTime) {
+		t.Errorf("throttle after reopening = %+v, want locked", lt)
+	}
+}
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testTime is what now returns in tests that stop the clock
+var testTime = time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+// stopClock makes now return testTime until the test ends
+func stopClock(t *testing.T) {
+	t.Helper()
+	setClock(t, testTime)
+}
+
+// setClock makes now return at until the test ends
+func setClock(t *testing.T, at time.Time) {
+	t.Helper()
+	old := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = old })
+}
+
+func TestDB_stampsOldRecords(t *testing.T) {
+	stopClock(t)
+	dir := t.TempDir()
+	chirpsPath := filepath.Join(dir, "storage.db")
+	usersPath := filepath.Join(dir, "users.db")
+	// files written before records carried timestamps
+	os.WriteFile(
+		chirpsPath,
+		[]byte(`{"chirps":{"1":{"id":1,"body":"old","author_id":1}}}`),
+		0600,
+	)
+	os.WriteFile(
+		usersPath,
+		[]byte(`{"users":{"1":{"id":1,"email":"old@example.com","password":"x"}},"addrs":{"old@example.com":1}}`),
+		0600,
+	)
+
+	db, err := NewDB(chirpsPath)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	userDB, err := NewUserDB(usersPath)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+
+	// the stamps are written back, so a later start sees the same ones
+	setClock(t, testTime.Add(time.Hour))
+	db, _ = NewDB(chirpsPath)
+	userDB, _ = NewUserDB(usersPath)
+
+	chirp, _ := db.GetChirp(1)
+	if !chirp.CreatedAt.Equal(testTime) || !chirp.UpdatedAt.Equal(testTime) {
+		t.Errorf("migrated chirp stamped %v/%v, want %v", chirp.CreatedAt, chirp.UpdatedAt, testTime)
+	}
+	user, _ := userDB.GetUser(1)
+	if !user.CreatedAt.Equal(testTime) || !user.UpdatedAt.Equal(testTime) {
+		t.Errorf("migrated user stamped %v/%v, want %v", user.CreatedAt, user.UpdatedAt, testTime)
+	}
+}
+
+func TestSQLiteDB_stampsOldRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.sqlite3")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a database from before timestamps were added
+	if err := migrate(old, sqliteMigrations[:2]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+INSERT INTO chirps (body, author_id) VALUES ('old', 1);
+INSERT INTO users (email, hashed_password) VALUES ('old@example.com', 'x');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDB() error = %v", err)
+	}
+	defer db.Close()
+
+	chirp, err := db.GetChirp(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.CreatedAt.IsZero() || !chirp.UpdatedAt.Equal(chirp.CreatedAt) {
+		t.Errorf("migrated chirp stamped %v/%v", chirp.CreatedAt, chirp.UpdatedAt)
+	}
+	user, err := db.GetUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.CreatedAt.IsZero() || !user.UpdatedAt.Equal(user.CreatedAt) {
+		t.Errorf("migrated user stamped %v/%v", user.CreatedAt, user.UpdatedAt)
+	}
+}
+
+func TestUserStore_updateStamps(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			setClock(t, testTime)
+			user, err := store.AddUser("walt@breakingbad.com", "123456")
+			if err != nil {
+				t.Fatalf("AddUser() error = %v", err)
+			}
+
+			updated := testTime.Add(time.Minute)
+			setClock(t, updated)
+			got, err := store.ChangeAccount(user.Id, AccountChange{Password: "654321"})
+			if err != nil {
+				t.Fatalf("ChangeAccount() error = %v", err)
+			}
+			if !got.CreatedAt.Equal(testTime) || !got.UpdatedAt.Equal(updated) {
+				t.Errorf("ChangeAccount() stamped %v/%v, want %v/%v", got.CreatedAt, got.UpdatedAt, testTime, updated)
+			}
+
+			upgraded := updated.Add(time.Minute)
+			setClock(t, upgraded)
+			if err := store.UpgradeUser(user.Id); err != nil {
+				t.Fatalf("UpgradeUser() error = %v", err)
+			}
+			got, _ = store.GetUser(user.Id)
+			if !got.CreatedAt.Equal(testTime) || !got.UpdatedAt.Equal(upgraded) {
+				t.Errorf("UpgradeUser() stamped %v/%v, want %v/%v", got.CreatedAt, got.UpdatedAt, testTime, upgraded)
+			}
+		})
+	}
+}
+
+func TestChirpStore_ChirpsPageByTime(t *testing.T) {
+	jsonDB, err := NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	stores := map[string]ChirpStore{
+		"json":   jsonDB,
+		"sqlite": setupSQLite(t),
+	}
+
+	// the clock is not in step with the IDs; 3 and 4 were posted together
+	minute := func(n int) time.Time { return testTime.Add(time.Duration(n) * time.Minute) }
+	posted := []time.Time{minute(3), minute(1), minute(2), minute(2), minute(4)}
+	cursor := func(id, n int) *ChirpCursor {
+		return &ChirpCursor{Id: id, CreatedAt: minute(n)}
+	}
+
+	tests := []struct {
+		name        string
+		query       ChirpQuery
+		wantIDs     []int
+		wantHasMore bool
+	}{
+		{
+			name:    "by creation time",
+			query:   ChirpQuery{SortBy: SortByCreatedAt},
+			wantIDs: []int{2, 3, 4, 1, 5},
+		},
+		{
+			name:    "by creation time descending",
+			query:   ChirpQuery{SortBy: SortByCreatedAt, Desc: true},
+			wantIDs: []int{5, 1, 4, 3, 2},
+		},
+		{
+			name:        "first page by creation time",
+			query:       ChirpQuery{SortBy: SortByCreatedAt, Limit: 2},
+			wantIDs:     []int{2, 3},
+			wantHasMore: true,
+		},
+		{
+			name: "after cursor between equal times",
+			query: ChirpQuery{
+				SortBy: SortByCreatedAt,
+				Limit:  2,
+				After:  cursor(3, 2),
+			},
+			wantIDs:     []int{4, 1},
+			wantHasMore: true,
+		},
+		{
+			name: "descending before cursor",
+			query: ChirpQuery{
+				SortBy: SortByCreatedAt,
+				Desc:   true,
+				Limit:  2,
+				Before: cursor(3, 2),
+			},
+			wantIDs:     []int{1, 4},
+			wantHasMore: true,
+		},
+		{
+			name:    "since and until",
+			query:   ChirpQuery{Since: minute(2), Until: minute(4)},
+			wantIDs: []int{1, 3, 4},
+		},
+		{
+			name: "since by creation time descending",
+			query: ChirpQuery{
+				SortBy: SortByCreatedAt,
+				Desc:   true,
+				Since:  minute(3),
+			},
+			wantIDs: []int{5, 1},
+		},
+		{
+			name:    "by author until",
+			query:   ChirpQuery{AuthorID: 1, Until: minute(3)},
+			wantIDs: []int{3},
+		},
+	}
+
+	for name, store := range stores {
+		// authors alternate 1, 2, 1, 2...
+		for i, at := range posted {
+			setClock(t, at)
+			if _, err := store.StoreChirp("timed", 2-(i+1)%2); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				got, err := store.ChirpsPage(tt.query)
+				if err != nil {
+					t.Fatalf("ChirpsPage() error = %v", err)
+				}
+				if ids := chirpIDs(got.Chirps); !reflect.DeepEqual(ids, tt.wantIDs) {
+					t.Errorf("ChirpsPage() ids = %v, want %v", ids, tt.wantIDs)
+				}
+				if got.HasMore != tt.wantHasMore {
+					t.Errorf("ChirpsPage() HasMore = %v, want %v", got.HasMore, tt.wantHasMore)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests are most useful under the race detector: go test -race
+
+func TestDB_UpdateConcurrent(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+
+	// every writer stores two chirps and deletes the first one;
+	// readers run alongside. A lost update leaves the wrong count.
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			first, err := db.StoreChirp("to be deleted", 1)
+			if err != nil {
+				t.Errorf("DB.StoreChirp() error = %v", err)
+				return
+			}
+			if _, err := db.StoreChirp("to be kept", 1); err != nil {
+				t.Errorf("DB.StoreChirp() error = %v", err)
+				return
+			}
+			if err := db.DeleteChirp(first.Id); err != nil {
+				t.Errorf("DB.DeleteChirp() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := db.GetChirps(true); err != nil {
+				t.Errorf("DB.GetChirps() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	chirps, err := db.GetChirps(false)
+	if err != nil {
+		t.Fatalf("DB.GetChirps() error = %v", err)
+	}
+	if len(chirps) != writers {
+		t.Errorf("DB.GetChirps() returned %d chirps, want %d", len(chirps), writers)
+	}
+	for _, ch := range chirps {
+		if ch.Body != "to be kept" {
+			t.Errorf("chirp %d should have been deleted", ch.Id)
+		}
+	}
+}
+
+func TestDB_UpdateRollback(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	if _, err := db.StoreChirp("keep me", 1); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := db.loadDB()
+
+	errAbort := errors.New("abort")
+	err = db.Update(func(dbStruct *DBStructure) error {
+		delete(dbStruct.Chirps, 1)
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("DB.Update() error = %v, want %v", err, errAbort)
+	}
+
+	after, _ := db.loadDB()
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("DB.Update() wrote a failed transaction: %v", after)
+	}
+}
+
+func TestUserDB_UpdateConcurrent(t *testing.T) {
+	db, err := NewUserDB(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	user, err := db.AddUser("walt@breakingbad.com", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 30
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.CreateSession(
+				user.Id,
+				"",
+				"",
+				fmt.Sprintf("token-%d", i),
+				time.Now().Add(time.Hour),
+			)
+			if err != nil {
+				t.Errorf("UserDB.CreateSession() error = %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if err := db.UpgradeUser(user.Id); err != nil {
+				t.Errorf("UserDB.UpgradeUser() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < writers; i++ {
+		if _, err := db.GetRefreshToken(fmt.Sprintf("token-%d", i)); err != nil {
+			t.Errorf("token-%d was lost: %v", i, err)
+		}
+	}
+	got, err := db.GetUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsChirpyRed {
+		t.Error("UserDB.UpgradeUser() was lost")
+	}
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// TwoFactor is a user's TOTP enrollment. It is pending until Enabled: a
+// secret has been handed out but no code from it confirmed yet.
+//
+// The secret has to be kept as is to check codes. Recovery codes are
+// kept as hashes and each can be used once.
+type TwoFactor struct {
+	UserID        int       `json:"user_id"`
+	Secret        string    `json:"secret"`
+	Enabled       bool      `json:"enabled"`
+	LastStep      int64     `json:"last_step"` // of the last code used
+	RecoveryCodes []string  `json:"recovery_codes"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+var (
+	// ErrTwoFactorNotFound is returned for users without an enrollment
+	ErrTwoFactorNotFound = errors.New("two-factor authentication is not set up")
+	// ErrTwoFactorEnabled is returned when enrolling a user who already is
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrCodeReused is returned for a TOTP code from a time step no later
+	// than the last one used
+	ErrCodeReused = errors.New("code was already used")
+	// ErrRecoveryCodeInvalid is returned for recovery codes that are
+	// unknown or used
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid")
+)
+
+// HashRecoveryCode is how recovery codes are stored and looked up
+func HashRecoveryCode(code string) string {
+	return hashToken(code)
+}
+
+// StartTwoFactor gives userID a new pending enrollment with secret,
+// replacing any pending one. It fails if two-factor is already enabled.
+func (db *UserDB) StartTwoFactor(userID int, secret string) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		if _, ok := dbStruct.Users[userID]; !ok {
+			return nil, errors.New(
+				fmt.Sprintf("Database does not contain User ID: %d", userID),
+			)
+		}
+		if tf, ok := dbStruct.TwoFactor[userID]; ok && tf.Enabled {
+			return nil, ErrTwoFactorEnabled
+		}
+		at := now()
+		tf := TwoFactor{
+			UserID:    userID,
+			Secret:    secret,
+			CreatedAt: at,
+			UpdatedAt: at,
+		}
+		return []userRecord{{Op: opTwoFactorSet, TwoFactor: &tf}}, nil
+	})
+}
+
+// EnableTwoFactor turns on userID's pending enrollment once a code from
+// step has been confirmed, with recoveryCodes (hashed) as the fallback
+func (db *UserDB) EnableTwoFactor(
+	userID int,
+	step int64,
+	recoveryCodes []string,
+) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		tf, ok := dbStruct.TwoFactor[userID]
+		if !ok {
+			return nil, ErrTwoFactorNotFound
+		}
+		tf.Enabled = true
+		tf.LastStep = step
+		tf.RecoveryCodes = slices.Clone(recoveryCodes)
+		tf.UpdatedAt = now()
+		return []userRecord{{Op: opTwoFactorSet, TwoFactor: &tf}}, nil
+	})
+}
+
+// DisableTwoFactor removes userID's enrollment, pending or not
+func (db *UserDB) DisableTwoFactor(userID int) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		tf, ok := dbStruct.TwoFactor[userID]
+		if !ok {
+			return nil, ErrTwoFactorNotFound
+		}
+		return []userRecord{{Op: opTwoFactorRemoved, TwoFactor: &tf}}, nil
+	})
+}
+
+func (db *UserDB) GetTwoFactor(userID int) (TwoFactor, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tf, ok := db.data.TwoFactor[userID]
+	if !ok {
+		return TwoFactor{}, ErrTwoFactorNotFound
+	}
+	tf.RecoveryCodes = slices.Clone(tf.RecoveryCodes)
+	return tf, nil
+}
+
+// UseTOTPStep records that a code from step was used, so that it and
+// older codes can't be used again
+func (db *UserDB) UseTOTPStep(userID int, step int64) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		tf, ok := dbStruct.TwoFactor[userID]
+		if !ok {
+			return nil, ErrTwoFactorNotFound
+		}
+		if step <= tf.LastStep {
+			return nil, ErrCodeReused
+		}
+		tf.LastStep = step
+		tf.UpdatedAt = now()
+		return []userRecord{{Op: opTwoFactorSet, TwoFactor: &tf}}, nil
+	})
+}
+
+// UseRecoveryCode uses up one of userID's recovery codes
+func (db *UserDB) UseRecoveryCode(userID int, code string) error {
+	return db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		tf, ok := dbStruct.TwoFactor[userID]
+		if !ok || !tf.Enabled {
+			return nil, ErrRecoveryCodeInvalid
+		}
+		hash := HashRecoveryCode(code)
+		i := slices.Index(tf.RecoveryCodes, hash)
+		if i < 0 {
+			return nil, ErrRecoveryCodeInvalid
+		}
+		// the slice is shared with the current state; don't modify it
+		tf.RecoveryCodes = slices.Delete(slices.Clone(tf.RecoveryCodes), i, i+1)
+		tf.UpdatedAt = now()
+		return []userRecord{{Op: opTwoFactorSet, TwoFactor: &tf}}, nil
+	})
+}
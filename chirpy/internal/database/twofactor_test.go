@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUserStore_TwoFactor(t *testing.T) {
+	stopClock(t)
+
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, err := store.AddUser("walt@breakingbad.com", "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetTwoFactor(user.Id); !errors.Is(err, ErrTwoFactorNotFound) {
+				t.Errorf("GetTwoFactor() before enrolling error = %v, want %v", err, ErrTwoFactorNotFound)
+			}
+			if err := store.StartTwoFactor(99, "SECRET"); err == nil {
+				t.Error("StartTwoFactor() accepted an unknown user")
+			}
+
+			// starting again replaces a pending enrollment
+			store.StartTwoFactor(user.Id, "FIRST")
+			if err := store.StartTwoFactor(user.Id, "SECOND"); err != nil {
+				t.Fatalf("StartTwoFactor() error = %v", err)
+			}
+			tf, err := store.GetTwoFactor(user.Id)
+			if err != nil {
+				t.Fatalf("GetTwoFactor() error = %v", err)
+			}
+			if tf.Secret != "SECOND" || tf.Enabled {
+				t.Errorf("GetTwoFactor() = %+v, want SECOND pending", tf)
+			}
+			if err := store.UseRecoveryCode(user.Id, "code-a"); !errors.Is(err, ErrRecoveryCodeInvalid) {
+				t.Errorf("UseRecoveryCode() while pending error = %v, want %v", err, ErrRecoveryCodeInvalid)
+			}
+
+			codes := []string{HashRecoveryCode("code-a"), HashRecoveryCode("code-b")}
+			if err := store.EnableTwoFactor(user.Id, 100, codes); err != nil {
+				t.Fatalf("EnableTwoFactor() error = %v", err)
+			}
+			if err := store.StartTwoFactor(user.Id, "THIRD"); !errors.Is(err, ErrTwoFactorEnabled) {
+				t.Errorf("StartTwoFactor() when enabled error = %v, want %v", err, ErrTwoFactorEnabled)
+			}
+
+			// codes can't be played back, nor older ones used
+			if err := store.UseTOTPStep(user.Id, 100); !errors.Is(err, ErrCodeReused) {
+				t.Errorf("UseTOTPStep() same step error = %v, want %v", err, ErrCodeReused)
+			}
+			if err := store.UseTOTPStep(user.Id, 101); err != nil {
+				t.Errorf("UseTOTPStep() next step error = %v", err)
+			}
+			if err := store.UseTOTPStep(user.Id, 99); !errors.Is(err, ErrCodeReused) {
+				t.Errorf("UseTOTPStep() older step error = %v, want %v", err, ErrCodeReused)
+			}
+
+			if err := store.UseRecoveryCode(user.Id, "code-a"); err != nil {
+				t.Errorf("UseRecoveryCode() error = %v", err)
+			}
+			if err := store.UseRecoveryCode(user.Id, "code-a"); !errors.Is(err, ErrRecoveryCodeInvalid) {
+				t.Errorf("UseRecoveryCode() again error = %v, want %v", err, ErrRecoveryCodeInvalid)
+			}
+			tf, _ = store.GetTwoFactor(user.Id)
+			if !tf.Enabled || tf.LastStep != 101 || len(tf.RecoveryCodes) != 1 {
+				t.Errorf("GetTwoFactor() = %+v, want enabled at step 101 with one code left", tf)
+			}
+
+			if err := store.DisableTwoFactor(user.Id); err != nil {
+				t.Fatalf("DisableTwoFactor() error = %v", err)
+			}
+			if _, err := store.GetTwoFactor(user.Id); !errors.Is(err, ErrTwoFactorNotFound) {
+				t.Errorf("GetTwoFactor() after disabling error = %v, want %v", err, ErrTwoFactorNotFound)
+			}
+			if err := store.UseRecoveryCode(user.Id, "code-b"); !errors.Is(err, ErrRecoveryCodeInvalid) {
+				t.Errorf("UseRecoveryCode() after disabling error = %v, want %v", err, ErrRecoveryCodeInvalid)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"slices"
 	"sync"
@@ -15,27 +16,80 @@ import (
 )
 
 type UserDB struct {
-	path string
-	mu   *sync.RWMutex
+	path      string
+	mu        *sync.RWMutex
+	backups   int
+	compactAt int64
+	// data is the authoritative copy of the database; it is read from
+	// disk once and every change is written through to the log
+	data  UserDBStructure
+	watch *watchState
 }
 
 type UserDBStructure struct {
-	Users         map[int]RegisteredUser `json:"users"`
-	Addrs         map[string]int         `json:"addrs"`
-	RevokedTokens map[string]time.Time   `json:"revoked_tokens"`
+	Version        int                      `json:"version"`
+	Users          map[int]RegisteredUser   `json:"users"`
+	Addrs          map[string]int           `json:"addrs"`
+	Handles        map[string]int           `json:"handles"`
+	RefreshTokens  map[string]RefreshToken  `json:"refresh_tokens"`
+	Sessions       map[string]Session       `json:"sessions"`
+	PasswordResets map[string]PasswordReset `json:"password_resets"`
+	// EmailVerifications are keyed by token hash
+	EmailVerifications map[string]EmailVerification `json:"email_verifications"`
+	TwoFactor          map[int]TwoFactor            `json:"two_factor"`
+	LoginThrottles     map[string]LoginThrottle     `json:"login_throttles"`
+	WebhookEvents      map[string]WebhookEvent      `json:"webhook_events"`
+	Sequences          map[string]int               `json:"sequences"`
+}
+
+const usersSeq = "users"
+
+func (dbStruct UserDBStructure) clone() UserDBStructure {
+	return UserDBStructure{
+		Version:            dbStruct.Version,
+		EmailVerifications: maps.Clone(dbStruct.EmailVerifications),
+		TwoFactor:          maps.Clone(dbStruct.TwoFactor),
+		LoginThrottles:     maps.Clone(dbStruct.LoginThrottles),
+		WebhookEvents:      maps.Clone(dbStruct.WebhookEvents),
+		Users:              maps.Clone(dbStruct.Users),
+		Addrs:              maps.Clone(dbStruct.Addrs),
+		Handles:            maps.Clone(dbStruct.Handles),
+		RefreshTokens:      maps.Clone(dbStruct.RefreshTokens),
+		Sessions:           maps.Clone(dbStruct.Sessions),
+		PasswordResets:     maps.Clone(dbStruct.PasswordResets),
+		Sequences:          maps.Clone(dbStruct.Sequences),
+	}
 }
 
 type RegisteredUser struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	HashedPw    string `json:"password"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id           int          `json:"id"`
+	Email        string       `json:"email"`
+	HashedPw     string       `json:"password"`
+	Subscription Subscription `json:"subscription"`
+	// LegacyChirpyRed is only set in files from before subscriptions
+	// were tracked; subscribeRedUsers migrates it
+	LegacyChirpyRed bool `json:"is_chirpy_red,omitempty"`
+	Role            Role `json:"role"`
+	Verified        bool `json:"verified"`
+	// PendingEmail is an address the user is changing to; Email stays
+	// in use until it is verified
+	PendingEmail string `json:"pending_email,omitempty"`
+	Profile
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type User struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id           int          `json:"id"`
+	Email        string       `json:"email"`
+	IsChirpyRed  bool         `json:"is_chirpy_red"`
+	Subscription Subscription `json:"subscription"`
+	Role         Role         `json:"role"`
+	Verified     bool         `json:"verified"`
+	PendingEmail string       `json:"pending_email,omitempty"`
+	Profile
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type SignedUser struct {
@@ -44,6 +98,7 @@ type SignedUser struct {
 	AccessToken  string `json:"token"`
 	RefreshToken string `json:"refresh_token"`
 	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	Role         Role   `json:"role"`
 }
 
 func (u *User) ToSignedUser(accessToken, refreshToken string) SignedUser {
@@ -53,131 +108,190 @@ func (u *User) ToSignedUser(accessToken, refreshToken string) SignedUser {
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		IsChirpyRed:  u.IsChirpyRed,
+		Role:         u.Role,
 	}
 }
 
 func (rg *RegisteredUser) toUser() User {
 	return User{
-		Id:          rg.Id,
-		Email:       rg.Email,
-		IsChirpyRed: rg.IsChirpyRed,
+		Id:           rg.Id,
+		Email:        rg.Email,
+		IsChirpyRed:  rg.Subscription.Red(now()),
+		Subscription: rg.Subscription,
+		Role:         rg.Role,
+		Verified:     rg.Verified,
+		PendingEmail: rg.PendingEmail,
+		Profile:      rg.Profile,
+		CreatedAt:    rg.CreatedAt,
+		UpdatedAt:    rg.UpdatedAt,
 	}
 }
 
-// NewUserDB creates a new database connection
-// and creates the database file if it doesn't exist
-func NewUserDB(path string) (*UserDB, error) {
-	db := &UserDB{
-		path: path,
-		mu:   &sync.RWMutex{},
-	}
-	err := db.ensureUserDB()
-	return db, err
+// userRecord is one change to the user store as written to the log
+type userRecord struct {
+	Seq          int                `json:"seq"`
+	Op           string             `json:"op"`
+	User         *RegisteredUser    `json:"user,omitempty"`
+	Refresh      *RefreshToken      `json:"refresh,omitempty"`
+	Session      *Session           `json:"session,omitempty"`
+	Reset        *PasswordReset     `json:"reset,omitempty"`
+	Verification *EmailVerification `json:"verification,omitempty"`
+	TwoFactor    *TwoFactor         `json:"two_factor,omitempty"`
+	Throttle     *LoginThrottle     `json:"throttle,omitempty"`
+	Webhook      *WebhookEvent      `json:"webhook,omitempty"`
+	Key          string             `json:"key,omitempty"`   // a login throttle key
+	Token        string             `json:"token,omitempty"` // a refresh or reset token hash
+	Family       string             `json:"family,omitempty"`
+	At           time.Time          `json:"at"`
 }
 
-func (db *UserDB) AddUser(body string, passwd string) (User, error) {
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		return User{}, err
-	}
+const (
+	opUserAdded           = "user.added"
+	opUserUpdated         = "user.updated"
+	opUserRoleSet         = "user.role_set"
+	opSessionStarted      = "session.started"
+	opRefreshIssued       = "refresh.issued"
+	opRefreshRotated      = "refresh.rotated"
+	opFamilyRevoked       = "refresh.family_revoked"
+	opRefreshPruned       = "refresh.pruned"
+	opResetIssued         = "password_reset.issued"
+	opPasswordReset       = "password_reset.used"
+	opVerificationIssued  = "email_verification.issued"
+	opEmailVerified       = "email_verification.used"
+	opTwoFactorSet        = "two_factor.set"
+	opTwoFactorRemoved    = "two_factor.removed"
+	opLoginFailed         = "login.failed"
+	opLoginCleared        = "login.cleared"
+	opLoginPruned         = "login.pruned"
+	opWebhookProcessed    = "webhook.processed"
+	opSubscriptionChanged = "subscription.changed"
+	opUserDeleted         = "user.deleted"
+)
 
-	// Check if user already registered
-	_, registered := dbStruct.Addrs[body]
-	if registered {
-		return User{}, errors.New("User is already registered")
+// apply makes the change rec describes
+func (rec userRecord) apply(dbStruct *UserDBStructure) {
+	switch rec.Op {
+	case opUserAdded, opUserUpdated, opUserRoleSet, opSubscriptionChanged:
+		putUser(dbStruct, *rec.User)
+	case opSessionStarted:
+		dbStruct.Sessions[rec.Session.ID] = *rec.Session
+		putRefreshToken(dbStruct, *rec.Refresh)
+	case opRefreshIssued:
+		putRefreshToken(dbStruct, *rec.Refresh)
+	case opRefreshRotated:
+		if rt, ok := dbStruct.RefreshTokens[rec.Token]; ok {
+			rt.RotatedAt = rec.At
+			dbStruct.RefreshTokens[rec.Token] = rt
+		}
+	case opFamilyRevoked:
+		revokeFamily(dbStruct, rec.Family, rec.At)
+	case opRefreshPruned:
+		pruneRefreshTokens(dbStruct, rec.At)
+	case opResetIssued:
+		putPasswordReset(dbStruct, *rec.Reset)
+	case opPasswordReset:
+		putUser(dbStruct, *rec.User)
+		if pr, ok := dbStruct.PasswordResets[rec.Token]; ok {
+			pr.UsedAt = rec.At
+			dbStruct.PasswordResets[rec.Token] = pr
+		}
+	case opTwoFactorSet:
+		dbStruct.TwoFactor[rec.TwoFactor.UserID] = *rec.TwoFactor
+	case opTwoFactorRemoved:
+		delete(dbStruct.TwoFactor, rec.TwoFactor.UserID)
+	case opLoginFailed:
+		dbStruct.LoginThrottles[rec.Throttle.Key] = *rec.Throttle
+	case opLoginCleared:
+		delete(dbStruct.LoginThrottles, rec.Key)
+	case opLoginPruned:
+		pruneLoginThrottles(dbStruct, rec.At)
+	case opUserDeleted:
+		deleteUser(dbStruct, *rec.User)
+	case opWebhookProcessed:
+		dbStruct.WebhookEvents[rec.Webhook.ID] = *rec.Webhook
+	case opVerificationIssued:
+		putUser(dbStruct, *rec.User)
+		putEmailVerification(dbStruct, *rec.Verification)
+	case opEmailVerified:
+		putUser(dbStruct, *rec.User)
+		if ev, ok := dbStruct.EmailVerifications[rec.Token]; ok {
+			ev.UsedAt = rec.At
+			dbStruct.EmailVerifications[rec.Token] = ev
+		}
 	}
+	dbStruct.Sequences[logSeq] = rec.Seq
+}
 
-	// Hash password
-	pw, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
-	if err != nil {
-		return User{}, err
-	}
-	id := len(dbStruct.Users) + 1
-	user := RegisteredUser{
-		Id:          id,
-		Email:       body,
-		HashedPw:    string(pw),
-		IsChirpyRed: false,
+// putUser stores user and points its email and handle at it,
+// dropping the address and handle it had before
+func putUser(dbStruct *UserDBStructure, user RegisteredUser) {
+	if old, ok := dbStruct.Users[user.Id]; ok {
+		delete(dbStruct.Addrs, old.Email)
+		if old.Handle != "" {
+			delete(dbStruct.Handles, old.Handle)
+		}
 	}
-
-	dbStruct.Users[id] = user
-	dbStruct.Addrs[body] = id
-	err = db.writeUserDB(dbStruct)
-	if err != nil {
-		return User{}, err
+	dbStruct.Users[user.Id] = user
+	dbStruct.Addrs[user.Email] = user.Id
+	if user.Handle != "" {
+		dbStruct.Handles[user.Handle] = user.Id
 	}
-
-	return user.toUser(), nil
+	ensureSequence(dbStruct.Sequences, usersSeq, user.Id)
 }
 
-func (db *UserDB) UpdateUser(id int, email, passwd string) (User, error) {
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		return User{}, err
+// NewUserDB creates a new database connection,
+// creates the database file if it doesn't exist
+// and loads it into memory
+func NewUserDB(path string, opts ...Option) (*UserDB, error) {
+	o := newFileOptions(opts)
+	db := &UserDB{
+		path:      path,
+		mu:        &sync.RWMutex{},
+		backups:   o.backups,
+		compactAt: o.compactAt,
 	}
+	err := db.ensureUserDB()
+	return db, err
+}
 
-	//////////////////////////
-	// Delete old entries first
-	// delete old email from Addrs db
-	oldUserData, ok := dbStruct.Users[id]
-	if !ok {
-		return User{}, errors.New(
-			fmt.Sprintf("Database does not contain User ID: %d", id),
-		)
-	}
-	delete(dbStruct.Addrs, oldUserData.Email)
-	/////////////////////////
-	// Hash password
+func (db *UserDB) AddUser(body string, passwd string) (User, error) {
+	// Hash password before taking the lock; bcrypt is slow
 	pw, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
 	if err != nil {
 		return User{}, err
 	}
-	// Should I be copying the token across?
-	user := RegisteredUser{
-		Id:       id,
-		Email:    email,
-		HashedPw: string(pw),
-	}
-	/////////////////////////
-	// Overwrite old entry in Users
-	dbStruct.Users[id] = user
-	dbStruct.Addrs[email] = id
-	err = db.writeUserDB(dbStruct)
-	if err != nil {
-		return User{}, err
-	}
-
-	return user.toUser(), nil
-}
-
-func (db *UserDB) UpgradeUser(userId int) error {
-
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		log.Println("--> DB: Could not load db")
-		return err
-	}
 
-	user := dbStruct.Users[userId]
-	user.IsChirpyRed = true
-	dbStruct.Users[userId] = user
+	user := RegisteredUser{}
+	err = db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		// Check if user already registered
+		_, registered := dbStruct.Addrs[body]
+		if registered {
+			return nil, ErrEmailTaken
+		}
 
-	err = db.writeUserDB(dbStruct)
+		at := now()
+		user = RegisteredUser{
+			Id:        dbStruct.Sequences[usersSeq] + 1,
+			Email:     body,
+			HashedPw:  string(pw),
+			Role:      RoleUser,
+			CreatedAt: at,
+			UpdatedAt: at,
+		}
+		return []userRecord{{Op: opUserAdded, User: &user}}, nil
+	})
 	if err != nil {
-		log.Println("--> DB: Could not write db")
-		return err
+		return User{}, err
 	}
 
-	log.Printf("--> DB: upgrading user %d to Chirpy Red", user.Id)
-	return nil
+	return user.toUser(), nil
 }
 
 func (db *UserDB) GetUsers() ([]User, error) {
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		return nil, err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
+	dbStruct := db.data
 	users := make([]User, 0, len(dbStruct.Users))
 	for _, v := range dbStruct.Users {
 		users = append(users, v.toUser())
@@ -192,11 +306,10 @@ func sortUserSlice(a, b User) int {
 }
 
 func (db *UserDB) GetUser(id int) (User, error) {
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		return User{}, err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
+	dbStruct := db.data
 	user, ok := dbStruct.Users[id]
 	if !ok {
 		return User{}, errors.New(
@@ -207,11 +320,10 @@ func (db *UserDB) GetUser(id int) (User, error) {
 }
 
 func (db *UserDB) GetUserId(email string) (int, error) {
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		return 0, err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
+	dbStruct := db.data
 	id, ok := dbStruct.Addrs[email]
 	if !ok {
 		return 0, errors.New("Could not get userID")
@@ -223,25 +335,23 @@ func (db *UserDB) GetUserId(email string) (int, error) {
 func (db *UserDB) GetUserDetails(
 	userId int,
 ) (email, hashedPW string, isChirpyRed bool, err error) {
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		return "", "", false, err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
+	dbStruct := db.data
 	user, ok := dbStruct.Users[userId]
 	if !ok {
 		return "", "", false, errors.New("Could not get userID")
 	}
 
-	return user.Email, user.HashedPw, user.IsChirpyRed, nil
+	return user.Email, user.HashedPw, user.Subscription.Red(now()), nil
 }
 
 func (db *UserDB) GetUserPassword(id int) (string, error) {
-	dbStruct, err := db.loadUserDB()
-	if err != nil {
-		return "", err
-	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
+	dbStruct := db.data
 	user, ok := dbStruct.Users[id]
 	if !ok {
 		return "", errors.New("Could not get userID")
@@ -262,40 +372,101 @@ func (db *UserDB) AuthenticateUser(email string, password string) (User, error)
 		return User{}, err
 	}
 
-	_, _, isChirpyRed, err := db.GetUserDetails(userID)
+	return db.GetUser(userID)
+}
+
+func (db *UserDB) ensureUserDB() error {
+	if _, err := os.Stat(db.path); errors.Is(err, os.ErrNotExist) {
+		dbStruct := UserDBStructure{
+			Version:            userDBVersion,
+			EmailVerifications: map[string]EmailVerification{},
+			TwoFactor:          map[int]TwoFactor{},
+			LoginThrottles:     map[string]LoginThrottle{},
+			WebhookEvents:      map[string]WebhookEvent{},
+			Users:              map[int]RegisteredUser{},
+			Addrs:              map[string]int{},
+			Handles:            map[string]int{},
+			RefreshTokens:      map[string]RefreshToken{},
+			Sessions:           map[string]Session{},
+			PasswordResets:     map[string]PasswordReset{},
+			Sequences:          map[string]int{},
+		}
+		return db.writeUserDB(dbStruct)
+	}
+
+	// the file exists; if it is damaged fall back to the newest good backup
+	err := recoverFile(db.path, db.backups, func(data []byte) error {
+		return json.Unmarshal(data, &UserDBStructure{})
+	})
 	if err != nil {
-		return User{}, err
+		return err
+	}
+
+	// read the snapshot and replay the log into memory
+	if err := repairLog[userRecord](logPath(db.path)); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.readUserDB()
+	if err != nil {
+		return err
 	}
-	user := User{
-		Id:          userID,
-		Email:       email,
-		IsChirpyRed: isChirpyRed,
+	stamped := stampUsers(dbStruct.Users, now())
+	roled := defaultRoles(dbStruct.Users)
+	verified := verifyExistingUsers(&dbStruct)
+	subscribed := subscribeRedUsers(&dbStruct)
+	db.data = dbStruct
+	if stamped || roled || verified || subscribed {
+		// persist the migration so it happens only once
+		return db.saveUserDB(dbStruct)
 	}
-	return user, nil
+	return db.compactIfNeeded(dbStruct)
 }
 
-func (db *UserDB) ensureUserDB() error {
-	if _, err := os.ReadFile(db.path); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			dbStruct := UserDBStructure{
-				Users:         map[int]RegisteredUser{},
-				Addrs:         map[string]int{},
-				RevokedTokens: map[string]time.Time{},
-			}
-			err := db.writeUserDB(dbStruct)
-			if err != nil {
-				return err
-			}
+// stampUsers gives users registered before records carried timestamps the
+// time they were migrated at, and reports whether any needed it
+func stampUsers(users map[int]RegisteredUser, at time.Time) bool {
+	stamped := false
+	for id, user := range users {
+		if !user.CreatedAt.IsZero() {
+			continue
 		}
+		user.CreatedAt = at
+		user.UpdatedAt = at
+		users[id] = user
+		stamped = true
 	}
-	return nil
+	return stamped
 }
 
-// loadUserDB reads the database file into memory
+// loadUserDB returns a copy of the database held in memory
 func (db *UserDB) loadUserDB() (UserDBStructure, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	return db.data.clone(), nil
+}
+
+// Reload replaces the copy held in memory with what is on disk.
+// It is needed only when another process has changed the files.
+func (db *UserDB) Reload() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dbStruct, err := db.readUserDB()
+	if err != nil {
+		return err
+	}
+	db.data = dbStruct
+	db.watch.remember(db.path)
+	return nil
+}
+
+// readUserDB reads the snapshot and replays the log on top of it;
+// callers must hold db.mu
+func (db *UserDB) readUserDB() (UserDBStructure, error) {
 	dbStruct := UserDBStructure{}
 	data, err := os.ReadFile(db.path)
 	if err != nil {
@@ -307,51 +478,150 @@ func (db *UserDB) loadUserDB() (UserDBStructure, error) {
 		return dbStruct, err
 	}
 
+	if dbStruct.Users == nil {
+		dbStruct.Users = map[int]RegisteredUser{}
+	}
+	if dbStruct.Addrs == nil {
+		dbStruct.Addrs = map[string]int{}
+	}
+	if dbStruct.Handles == nil {
+		dbStruct.Handles = indexHandles(dbStruct.Users)
+	}
+	if dbStruct.RefreshTokens == nil {
+		dbStruct.RefreshTokens = map[string]RefreshToken{}
+	}
+	if dbStruct.Sessions == nil {
+		dbStruct.Sessions = map[string]Session{}
+	}
+	if dbStruct.PasswordResets == nil {
+		dbStruct.PasswordResets = map[string]PasswordReset{}
+	}
+	if dbStruct.EmailVerifications == nil {
+		dbStruct.EmailVerifications = map[string]EmailVerification{}
+	}
+	if dbStruct.TwoFactor == nil {
+		dbStruct.TwoFactor = map[int]TwoFactor{}
+	}
+	if dbStruct.LoginThrottles == nil {
+		dbStruct.LoginThrottles = map[string]LoginThrottle{}
+	}
+	if dbStruct.WebhookEvents == nil {
+		dbStruct.WebhookEvents = map[string]WebhookEvent{}
+	}
+	if dbStruct.Sequences == nil {
+		dbStruct.Sequences = map[string]int{}
+	}
+	ensureSequence(dbStruct.Sequences, usersSeq, maxKey(dbStruct.Users))
+
+	path := logPath(db.path)
+	records, _, err := readRecords[userRecord](path)
+	if err != nil {
+		log.Println(err)
+		return dbStruct, err
+	}
+	records, err = recordsAfter(path, records, dbStruct.Sequences[logSeq])
+	if err != nil {
+		log.Printf("--> DB: %s", err)
+		return dbStruct, err
+	}
+	for _, rec := range records {
+		rec.apply(&dbStruct)
+	}
+
 	return dbStruct, nil
 }
 
-func (db *UserDB) writeUserDB(dbStructure UserDBStructure) error {
+// commit is how the store's own methods change data. fn looks at the
+// current state and returns records describing the change, without
+// modifying the state itself. The records are appended to the log, so a
+// change costs one small write rather than rewriting the whole file, and
+// are applied to the copy in memory once they are on disk.
+func (db *UserDB) commit(fn func(*UserDBStructure) ([]userRecord, error)) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	bytes, err := json.Marshal(dbStructure)
-	if err != nil {
+	records, err := fn(&db.data)
+	if err != nil || len(records) == 0 {
 		return err
 	}
 
-	err = os.WriteFile(db.path, bytes, 0600)
-	if err != nil {
+	for i := range records {
+		records[i].Seq = db.data.Sequences[logSeq] + i + 1
+	}
+	if err := appendRecords(logPath(db.path), records); err != nil {
 		return err
 	}
-	return nil
+	for _, rec := range records {
+		rec.apply(&db.data)
+	}
+	db.watch.remember(db.path)
+
+	return db.compactIfNeeded(db.data)
 }
 
-func (db *UserDB) AddRevokedToken(refreshToken string) error {
-	dbStruct, err := db.loadUserDB()
+// compactIfNeeded folds the log into a new snapshot once it is too big;
+// callers must hold db.mu
+func (db *UserDB) compactIfNeeded(dbStructure UserDBStructure) error {
+	size, err := logSize(logPath(db.path))
 	if err != nil {
 		return err
 	}
+	if size < db.compactAt {
+		return nil
+	}
+	return db.saveUserDB(dbStructure)
+}
 
-	dbStruct.RevokedTokens[refreshToken] = time.Now()
+// Update runs fn as a read-modify-write transaction. The write lock is held
+// from reading the file until the result is written back, so concurrent
+// updates can't overwrite each other. If fn returns an error nothing is
+// written. The result is written as a full snapshot.
+//
+// fn works on a copy of the maps, so a failed transaction leaves the data
+// in memory untouched. Slices inside records are shared; replace them
+// rather than modifying them in place.
+func (db *UserDB) Update(fn func(*UserDBStructure) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-	err = db.writeUserDB(dbStruct)
-	if err != nil {
+	dbStruct := db.data.clone()
+	if err := fn(&dbStruct); err != nil {
 		return err
 	}
+	if err := db.saveUserDB(dbStruct); err != nil {
+		return err
+	}
+	db.data = dbStruct
+	return nil
+}
+
+func (db *UserDB) writeUserDB(dbStructure UserDBStructure) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
+	if err := db.saveUserDB(dbStructure); err != nil {
+		return err
+	}
+	db.data = dbStructure
 	return nil
 }
 
-func (db *UserDB) IsRevoked(refreshToken string) (bool, error) {
-	dbStruct, err := db.loadUserDB()
+// saveUserDB does the work of writeUserDB; callers must hold db.mu.
+// The snapshot includes everything in the log, so the log is removed.
+func (db *UserDB) saveUserDB(dbStructure UserDBStructure) error {
+	bytes, err := json.Marshal(dbStructure)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	_, ok := dbStruct.RevokedTokens[refreshToken]
-	if ok {
-		return true, nil
+	err = writeFileAtomic(db.path, bytes, db.backups)
+	if err != nil {
+		return err
 	}
-
-	return false, nil
+	err = removeLog(logPath(db.path))
+	if err != nil {
+		return err
+	}
+	db.watch.remember(db.path)
+	return nil
 }
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EmailVerification is an email verification token as stored; only its
+// hash is kept. It confirms that UserID can read mail sent to Email,
+// which is either the address they registered with or the one they asked
+// to change to.
+type EmailVerification struct {
+	Hash      string    `json:"hash"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at"` // zero until used
+}
+
+var (
+	// ErrVerificationInvalid is returned for verification tokens that are
+	// unknown, expired, replaced or already used
+	ErrVerificationInvalid = errors.New("email verification token is invalid")
+	// ErrEmailTaken is returned when an address is already registered
+	ErrEmailTaken = errors.New("email is already registered")
+)
+
+// usable reports whether the verification can still be used at time at
+func (ev EmailVerification) usable(at time.Time) bool {
+	return ev.UsedAt.IsZero() && at.Before(ev.ExpiresAt)
+}
+
+// CreateEmailVerification stores token as the way for userID to confirm
+// email. If email isn't the user's address it becomes their pending one;
+// the current address stays in use until the new one is confirmed.
+func (db *UserDB) CreateEmailVerification(
+	userID int,
+	email, token string,
+	expiresAt time.Time,
+) (User, error) {
+	user := RegisteredUser{}
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		var ok bool
+		user, ok = dbStruct.Users[userID]
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("Database does not contain User ID: %d", userID),
+			)
+		}
+		if id, taken := dbStruct.Addrs[email]; taken && id != userID {
+			return nil, ErrEmailTaken
+		}
+
+		ev := pendEmail(&user, email, token, expiresAt, now())
+		return []userRecord{
+			{Op: opVerificationIssued, User: &user, Verification: &ev},
+		}, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user.toUser(), nil
+}
+
+// pendEmail makes email the user's pending address, unless it is already
+// theirs, and returns the verification for token that confirms it
+func pendEmail(
+	user *RegisteredUser,
+	email, token string,
+	expiresAt, at time.Time,
+) EmailVerification {
+	if email == user.Email {
+		user.PendingEmail = ""
+	} else {
+		user.PendingEmail = email
+		user.UpdatedAt = at
+	}
+	return EmailVerification{
+		Hash:      hashToken(token),
+		UserID:    user.Id,
+		Email:     email,
+		CreatedAt: at,
+		ExpiresAt: expiresAt.UTC(),
+	}
+}
+
+// VerifyEmail uses up token, marking its user verified. If the token was
+// for a pending address, that address replaces the user's old one.
+func (db *UserDB) VerifyEmail(token string) (User, error) {
+	user := RegisteredUser{}
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		at := now()
+		ev, ok := dbStruct.EmailVerifications[hashToken(token)]
+		if !ok || !ev.usable(at) {
+			return nil, ErrVerificationInvalid
+		}
+		user, ok = dbStruct.Users[ev.UserID]
+		if !ok {
+			return nil, ErrVerificationInvalid
+		}
+
+		switch ev.Email {
+		case user.Email:
+		case user.PendingEmail:
+			if id, taken := dbStruct.Addrs[ev.Email]; taken && id != user.Id {
+				return nil, ErrEmailTaken
+			}
+			user.Email = ev.Email
+			user.PendingEmail = ""
+		default:
+			// the user has since changed address
+			return nil, ErrVerificationInvalid
+		}
+		user.Verified = true
+		user.UpdatedAt = at
+		return []userRecord{
+			{Op: opEmailVerified, User: &user, Token: ev.Hash, At: at},
+		}, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user.toUser(), nil
+}
+
+// putEmailVerification stores ev in place of the user's other
+// verifications. Ones that had expired or been used by the time it was
+// issued are dropped.
+func putEmailVerification(dbStruct *UserDBStructure, ev EmailVerification) {
+	for hash, old := range dbStruct.EmailVerifications {
+		if old.UserID == ev.UserID || !old.usable(ev.CreatedAt) {
+			delete(dbStruct.EmailVerifications, hash)
+		}
+	}
+	dbStruct.EmailVerifications[ev.Hash] = ev
+}
+
+// userDBVersion is the version of the users file this code writes.
+// Version 1 added email verification, and 2 subscriptions.
+const userDBVersion = 2
+
+// verifyExistingUsers counts users registered before email verification
+// existed as verified, so they aren't restricted for not having done it,
+// and reports whether the file needed it
+func verifyExistingUsers(dbStruct *UserDBStructure) bool {
+	if dbStruct.Version >= 1 {
+		return false
+	}
+	for id, user := range dbStruct.Users {
+		user.Verified = true
+		dbStruct.Users[id] = user
+	}
+	dbStruct.Version = 1
+	return true
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUserStore_VerifyEmail(t *testing.T) {
+	stopClock(t)
+	expires := testTime.Add(time.Hour)
+
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, err := store.AddUser("walt@breakingbad.com", "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.AddUser("jesse@breakingbad.com", "abcdef")
+			if user.Verified {
+				t.Error("AddUser() made a verified user")
+			}
+
+			if _, err := store.CreateEmailVerification(user.Id, "walt@breakingbad.com", "signup", expires); err != nil {
+				t.Fatalf("CreateEmailVerification() error = %v", err)
+			}
+			got, err := store.VerifyEmail("signup")
+			if err != nil {
+				t.Fatalf("VerifyEmail() error = %v", err)
+			}
+			if !got.Verified || got.Email != "walt@breakingbad.com" {
+				t.Errorf("VerifyEmail() = %+v, want walt verified", got)
+			}
+			if _, err := store.VerifyEmail("signup"); !errors.Is(err, ErrVerificationInvalid) {
+				t.Errorf("VerifyEmail() reused token error = %v, want %v", err, ErrVerificationInvalid)
+			}
+
+			// someone else's address can't be claimed
+			_, err = store.CreateEmailVerification(user.Id, "jesse@breakingbad.com", "steal", expires)
+			if !errors.Is(err, ErrEmailTaken) {
+				t.Errorf("CreateEmailVerification() taken address error = %v, want %v", err, ErrEmailTaken)
+			}
+
+			// a change of address waits for the new one to be confirmed
+			got, err = store.CreateEmailVerification(user.Id, "heisenberg@breakingbad.com", "change", expires)
+			if err != nil {
+				t.Fatalf("CreateEmailVerification() error = %v", err)
+			}
+			if got.Email != "walt@breakingbad.com" || got.PendingEmail != "heisenberg@breakingbad.com" {
+				t.Errorf("CreateEmailVerification() = %+v, want the new address pending", got)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "123456"); err != nil {
+				t.Errorf("AuthenticateUser() with the old address before confirming error = %v", err)
+			}
+			if _, err := store.AuthenticateUser("heisenberg@breakingbad.com", "123456"); err == nil {
+				t.Error("AuthenticateUser() accepted the unconfirmed address")
+			}
+
+			got, err = store.VerifyEmail("change")
+			if err != nil {
+				t.Fatalf("VerifyEmail() error = %v", err)
+			}
+			if got.Email != "heisenberg@breakingbad.com" || got.PendingEmail != "" || !got.Verified {
+				t.Errorf("VerifyEmail() = %+v, want the new address in use", got)
+			}
+			if _, err := store.AuthenticateUser("heisenberg@breakingbad.com", "123456"); err != nil {
+				t.Errorf("AuthenticateUser() with the new address error = %v", err)
+			}
+			if _, err := store.AuthenticateUser("walt@breakingbad.com", "123456"); err == nil {
+				t.Error("AuthenticateUser() accepted the old address")
+			}
+
+			// a token for an address given up since is no use
+			store.CreateEmailVerification(user.Id, "first@breakingbad.com", "first", expires)
+			store.CreateEmailVerification(user.Id, "second@breakingbad.com", "second", expires)
+			if _, err := store.VerifyEmail("first"); !errors.Is(err, ErrVerificationInvalid) {
+				t.Errorf("VerifyEmail() replaced token error = %v, want %v", err, ErrVerificationInvalid)
+			}
+		})
+	}
+}
+
+func TestUserStore_VerifyEmailExpires(t *testing.T) {
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			setClock(t, testTime)
+			user, _ := store.AddUser("walt@breakingbad.com", "123456")
+			store.CreateEmailVerification(user.Id, user.Email, "signup", testTime.Add(time.Hour))
+
+			setClock(t, testTime.Add(time.Hour))
+			if _, err := store.VerifyEmail("signup"); !errors.Is(err, ErrVerificationInvalid) {
+				t.Errorf("VerifyEmail() expired token error = %v, want %v", err, ErrVerificationInvalid)
+			}
+		})
+	}
+}
+
+func TestUserDB_verifiesExistingUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	// a file written before email verification
+	os.WriteFile(
+		path,
+		[]byte(`{"users":{"1":{"id":1,"email":"old@example.com","password":"x"}},"addrs":{"old@example.com":1}}`),
+		0600,
+	)
+
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	old, _ := db.GetUser(1)
+	if !old.Verified {
+		t.Error("user from before verification is not verified")
+	}
+
+	// the migration runs once; users registered after it are not verified
+	user, _ := db.AddUser("new@example.com", "123456")
+	db, err = NewUserDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetUser(user.Id); got.Verified {
+		t.Error("user registered after the migration is verified")
+	}
+}
+
+func TestSQLiteDB_verifiesExistingUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.sqlite3")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(old, sqliteMigrations[:7]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(
+		`INSERT INTO users (email, hashed_password, created_at, updated_at)
+		 VALUES ('old@example.com', 'x', ?, ?)`,
+		testTime,
+		testTime,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDB() error = %v", err)
+	}
+	defer db.Close()
+	user, err := db.GetUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Verified {
+		t.Error("user from before verification is not verified")
+	}
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// The JSON stores don't rewrite the whole file for every change. Mutations
+// are appended as records to a log next to the snapshot (path + ".log")
+// and replayed on top of it when the database is read. Once the log grows
+// past the compaction threshold the current state is written out as a new
+// snapshot and the log is started again.
+//
+// Every record carries a sequence number and the snapshot remembers the
+// last one it includes, so records that are already part of a snapshot
+// are skipped if the log outlives it (a crash between writing the
+// snapshot and removing the log). The first record replayed must follow
+// straight on from the snapshot: a snapshot restored from an older backup
+// can be missing records that were folded into a newer one, and starting
+// without them would silently lose those writes.
+
+const (
+	logSeq                  = "log"
+	defaultCompactThreshold = 1 << 20
+)
+
+// WithCompactThreshold sets the log size in bytes
+// after which the log is folded into a new snapshot
+func WithCompactThreshold(n int64) Option {
+	return func(o *fileOptions) {
+		o.compactAt = n
+	}
+}
+
+func logPath(path string) string {
+	return path + ".log"
+}
+
+// sequenced is a log record
+type sequenced interface {
+	sequence() int
+}
+
+func (rec chirpRecord) sequence() int { return rec.Seq }
+func (rec userRecord) sequence() int  { return rec.Seq }
+
+// recordsAfter returns the records in the log at path that come after the
+// snapshot's last record, snapshotSeq. It is an error if they don't start
+// with the record that follows it.
+func recordsAfter[R sequenced](path string, records []R, snapshotSeq int) ([]R, error) {
+	for i, rec := range records {
+		if rec.sequence() <= snapshotSeq {
+			continue
+		}
+		if rec.sequence() != snapshotSeq+1 {
+			return nil, fmt.Errorf(
+				"%s resumes at record %d but the snapshot ends at record %d; "+
+					"the records between them are missing",
+				path,
+				rec.sequence(),
+				snapshotSeq,
+			)
+		}
+		return records[i:], nil
+	}
+	return nil, nil
+}
+
+// appendRecords writes records to the log as JSON lines and fsyncs it
+func appendRecords[R any](path string, records []R) error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readRecords reads every complete record in the log. A final line that
+// is cut short (a crash during append) is ignored; validLen is the length
+// of the log up to the last complete record.
+func readRecords[R any](path string) (records []R, validLen int64, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("--> DB: ignoring incomplete record at end of %s", path)
+			}
+			return records, validLen, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var rec R
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, 0, fmt.Errorf("%s line %d: %w", path, lineNo, err)
+		}
+		records = append(records, rec)
+		validLen += int64(len(line))
+	}
+}
+
+// repairLog cuts off an incomplete record left at the end of the log so
+// that new records are appended after the last good one
+func repairLog[R any](path string) error {
+	_, validLen, err := readRecords[R](path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == validLen {
+		return nil
+	}
+	return os.Truncate(path, validLen)
+}
+
+func logSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func removeLog(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
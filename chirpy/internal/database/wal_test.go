@@ -0,0 +1,146 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDB_logReplay(t *testing.T) {
+	stopClock(t)
+	path := filepath.Join(t.TempDir(), "storage.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	snapshot, _ := os.ReadFile(path)
+
+	db.StoreChirp("first", 1)
+	db.StoreChirp("second", 2)
+	db.DeleteChirp(1)
+
+	// mutations only touch the log
+	after, _ := os.ReadFile(path)
+	if string(after) != string(snapshot) {
+		t.Errorf("snapshot was rewritten: %s", after)
+	}
+	records, _, err := readRecords[chirpRecord](logPath(path))
+	if err != nil || len(records) != 3 {
+		t.Fatalf("log has %d records (err %v), want 3", len(records), err)
+	}
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	got, err := db.GetChirps(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Chirp{{Id: 2, Body: "second", AuthorId: 2, CreatedAt: testTime, UpdatedAt: testTime}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed chirps = %v, want %v", got, want)
+	}
+}
+
+func TestDB_logCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	db, err := NewDB(path, WithCompactThreshold(200))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := db.StoreChirp("a chirp long enough to fill the log", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size, _ := logSize(logPath(path))
+	if size >= 200 {
+		t.Errorf("log is %d bytes, should have been compacted", size)
+	}
+
+	// the snapshot alone must hold whatever left the log
+	dbStruct := DBStructure{}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &dbStruct); err != nil {
+		t.Fatal(err)
+	}
+	records, _, _ := readRecords[chirpRecord](logPath(path))
+	if len(dbStruct.Chirps)+len(records) != 5 {
+		t.Errorf(
+			"snapshot has %d chirps and log %d records, want 5 in total",
+			len(dbStruct.Chirps),
+			len(records),
+		)
+	}
+}
+
+func TestDB_logStaleAfterSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	db.StoreChirp("first", 1)
+	staleLog, _ := os.ReadFile(logPath(path))
+
+	// a snapshot that changes the chirp, then a crash leaves the old log
+	err = db.Update(func(dbStruct *DBStructure) error {
+		chirp := dbStruct.Chirps[1]
+		chirp.Body = "edited"
+		dbStruct.Chirps[1] = chirp
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(logPath(path), staleLog, 0600)
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	chirp, _ := db.GetChirp(1)
+	if chirp.Body != "edited" {
+		t.Errorf("stale log record was replayed over the snapshot: %v", chirp)
+	}
+}
+
+func TestUserDB_logTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	if _, err := db.AddUser("walt@breakingbad.com", "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash in the middle of appending the next record
+	f, _ := os.OpenFile(logPath(path), os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"seq":2,"op":"user.ad`)
+	f.Close()
+
+	db, err = NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	if _, err := db.AddUser("jesse@breakingbad.com", "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	users, err := db.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Errorf("UserDB.GetUsers() = %v, want both users", users)
+	}
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// fileStamp identifies a version of the snapshot and log on disk
+type fileStamp struct {
+	snapshotMod  int64
+	snapshotSize int64
+	logMod       int64
+	logSize      int64
+}
+
+func stampFiles(path string) fileStamp {
+	stamp := fileStamp{}
+	if info, err := os.Stat(path); err == nil {
+		stamp.snapshotMod = info.ModTime().UnixNano()
+		stamp.snapshotSize = info.Size()
+	}
+	if info, err := os.Stat(logPath(path)); err == nil {
+		stamp.logMod = info.ModTime().UnixNano()
+		stamp.logSize = info.Size()
+	}
+	return stamp
+}
+
+// watchState remembers what the files looked like the last time the store
+// wrote or read them, so that Watch only reloads for changes made by
+// someone else. It is guarded by the store's mutex.
+type watchState struct {
+	seen fileStamp
+}
+
+func (w *watchState) remember(path string) {
+	if w == nil {
+		return
+	}
+	w.seen = stampFiles(path)
+}
+
+func (w *watchState) changed(path string) bool {
+	return stampFiles(path) != w.seen
+}
+
+// Watch starts checking the database files every interval and reloads
+// them when another process has changed them. Call stop to end it.
+func (db *DB) Watch(interval time.Duration) (stop func()) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.watch == nil {
+		db.watch = &watchState{}
+		db.watch.remember(db.path)
+	}
+	return pollFiles(interval, db.path, db.changedOnDisk, db.Reload)
+}
+
+func (db *DB) changedOnDisk() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.watch.changed(db.path)
+}
+
+// Watch starts checking the database files every interval and reloads
+// them when another process has changed them. Call stop to end it.
+func (db *UserDB) Watch(interval time.Duration) (stop func()) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.watch == nil {
+		db.watch = &watchState{}
+		db.watch.remember(db.path)
+	}
+	return pollFiles(interval, db.path, db.changedOnDisk, db.Reload)
+}
+
+func (db *UserDB) changedOnDisk() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.watch.changed(db.path)
+}
+
+func pollFiles(
+	interval time.Duration,
+	path string,
+	changed func() bool,
+	reload func() error,
+) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if !changed() {
+				continue
+			}
+			log.Printf("--> DB: %s changed on disk, reloading", path)
+			if err := reload(); err != nil {
+				log.Printf("--> DB: could not reload %s: %s", path, err)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	// another process working on the same files
+	other, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	if _, err := other.StoreChirp("from elsewhere", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetChirp(1); err == nil {
+		t.Fatal("DB.GetChirp() read from disk instead of memory")
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatalf("DB.Reload() error = %v", err)
+	}
+	if _, err := db.GetChirp(1); err != nil {
+		t.Errorf("DB.GetChirp() after reload: %s", err)
+	}
+}
+
+func TestUserDB_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	stop := db.Watch(5 * time.Millisecond)
+	defer stop()
+
+	other, err := NewUserDB(path)
+	if err != nil {
+		t.Fatalf("NewUserDB() error = %v", err)
+	}
+	if _, err := other.AddUser("walt@breakingbad.com", "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := db.GetUserId("walt@breakingbad.com"); err == nil {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("UserDB.Watch() did not pick up the change")
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrWebhookEventSeen     = errors.New("webhook event already processed")
+	ErrWebhookEventNotFound = errors.New("webhook event not found")
+)
+
+// WebhookEvent is a webhook delivery that was processed. The ledger of
+// them lets redeliveries be recognised, and Payload keeps the body
+// exactly as it was received for audit.
+type WebhookEvent struct {
+	ID          string    `json:"id"`
+	Event       string    `json:"event"`
+	Payload     string    `json:"payload"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// SubscriptionChange is what a webhook event does to a user's
+// subscription
+type SubscriptionChange struct {
+	UserID    int
+	Event     SubscriptionEvent
+	PeriodEnd time.Time
+}
+
+// ProcessWebhookEvent adds ev to the ledger, stamped with the time, and
+// makes change, if there is one, in the same write, so an event is never
+// applied twice. If an event with ev's ID is already there it changes
+// nothing and returns ErrWebhookEventSeen. A change that doesn't apply to
+// the subscription never will, so ev is recorded all the same and
+// returned with ErrInvalidTransition; other failures record nothing, so
+// the event can be retried.
+func (db *UserDB) ProcessWebhookEvent(
+	ev WebhookEvent,
+	change *SubscriptionChange,
+) (WebhookEvent, error) {
+	var changeErr error
+	err := db.commit(func(dbStruct *UserDBStructure) ([]userRecord, error) {
+		if _, ok := dbStruct.WebhookEvents[ev.ID]; ok {
+			return nil, ErrWebhookEventSeen
+		}
+		records := []userRecord{}
+		if change != nil {
+			user, err := changeSubscription(
+				dbStruct,
+				change.UserID,
+				change.Event,
+				change.PeriodEnd,
+			)
+			switch {
+			case errors.Is(err, ErrInvalidTransition):
+				changeErr = err
+			case err != nil:
+				return nil, err
+			default:
+				records = append(records, userRecord{Op: opSubscriptionChanged, User: &user})
+			}
+		}
+		ev.ProcessedAt = now()
+		return append(records, userRecord{Op: opWebhookProcessed, Webhook: &ev}), nil
+	})
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	return ev, changeErr
+}
+
+// GetWebhookEvent returns the processed event with id
+func (db *UserDB) GetWebhookEvent(id string) (WebhookEvent, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ev, ok := db.data.WebhookEvents[id]
+	if !ok {
+		return WebhookEvent{}, ErrWebhookEventNotFound
+	}
+	return ev, nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserStore_WebhookEvents(t *testing.T) {
+	stopClock(t)
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ev := WebhookEvent{
+				ID:      "evt_1",
+				Event:   "user.upgraded",
+				Payload: `{"id":"evt_1","event":"user.upgraded","data":{"user_id":1}}`,
+			}
+			if _, err := store.GetWebhookEvent(ev.ID); !errors.Is(err, ErrWebhookEventNotFound) {
+				t.Errorf("GetWebhookEvent() before recording error = %v, want ErrWebhookEventNotFound", err)
+			}
+			recorded, err := store.ProcessWebhookEvent(ev, nil)
+			if err != nil {
+				t.Fatalf("ProcessWebhookEvent() error = %v", err)
+			}
+			ev.ProcessedAt = testTime
+			if recorded != ev {
+				t.Errorf("ProcessWebhookEvent() = %+v, want %+v", recorded, ev)
+			}
+			if got, err := store.GetWebhookEvent(ev.ID); err != nil || got != ev {
+				t.Errorf("GetWebhookEvent() = %+v, %v; want %+v", got, err, ev)
+			}
+
+			again := ev
+			again.Payload = "{}"
+			if _, err := store.ProcessWebhookEvent(again, nil); !errors.Is(err, ErrWebhookEventSeen) {
+				t.Errorf("recording a redelivery error = %v, want ErrWebhookEventSeen", err)
+			}
+			if got, _ := store.GetWebhookEvent(ev.ID); got.Payload != ev.Payload {
+				t.Errorf("redelivery replaced the payload with %q", got.Payload)
+			}
+		})
+	}
+}
+
+func TestUserStore_ProcessWebhookEvent(t *testing.T) {
+	stopClock(t)
+	for name, store := range setupUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, _ := store.AddUser("walt@breakingbad.com", "123456")
+			process := func(id string, userId int, event SubscriptionEvent) error {
+				t.Helper()
+				_, err := store.ProcessWebhookEvent(
+					WebhookEvent{ID: id, Event: string(event), Payload: "{}"},
+					&SubscriptionChange{UserID: userId, Event: event},
+				)
+				return err
+			}
+
+			if err := process("evt_0", 99, EventUpgraded); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("ProcessWebhookEvent() unknown user error = %v, want ErrUserNotFound", err)
+			}
+			if _, err := store.GetWebhookEvent("evt_0"); !errors.Is(err, ErrWebhookEventNotFound) {
+				t.Errorf("failed event was recorded, error = %v", err)
+			}
+
+			if err := process("evt_1", user.Id, EventCancelled); !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("ProcessWebhookEvent() invalid transition error = %v, want ErrInvalidTransition", err)
+			}
+			if _, err := store.GetWebhookEvent("evt_1"); err != nil {
+				t.Errorf("event that doesn't apply was not recorded: %v", err)
+			}
+
+			if err := process("evt_2", user.Id, EventUpgraded); err != nil {
+				t.Fatalf("ProcessWebhookEvent() error = %v", err)
+			}
+			if err := process("evt_3", user.Id, EventCancelled); err != nil {
+				t.Fatalf("ProcessWebhookEvent() error = %v", err)
+			}
+			if err := process("evt_4", user.Id, EventUpgraded); err != nil {
+				t.Fatalf("ProcessWebhookEvent() error = %v", err)
+			}
+			// replaying the cancellation after the renewal changes nothing
+			if err := process("evt_3", user.Id, EventCancelled); !errors.Is(err, ErrWebhookEventSeen) {
+				t.Errorf("ProcessWebhookEvent() redelivery error = %v, want ErrWebhookEventSeen", err)
+			}
+			if got, _ := store.GetUser(user.Id); got.Subscription.Status != SubscriptionActive {
+				t.Errorf("subscription after a redelivery = %s, want active", got.Subscription.Status)
+			}
+		})
+	}
+}
+
+func TestUserDB_WebhookEventsPersist(t *testing.T) {
+	stopClock(t)
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := NewUserDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ProcessWebhookEvent(WebhookEvent{ID: "evt_1", Payload: "{}"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewUserDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetWebhookEvent("evt_1"); err != nil {
+		t.Errorf("GetWebhookEvent() after reopening error = %v", err)
+	}
+}
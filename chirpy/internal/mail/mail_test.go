@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer_Send(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf)
+	err := m.Send(Message{
+		To:      "walt@breakingbad.com\r\nBcc: jesse@breakingbad.com",
+		Subject: "Hello",
+		Body:    "Say my name.\nHeisenberg.",
+	})
+	if err != nil {
+		t.Fatalf("LogMailer.Send() error = %v", err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{
+		"To: walt@breakingbad.com  Bcc: jesse@breakingbad.com\r\n",
+		"Subject: Hello\r\n",
+		"\r\n\r\nSay my name.\r\nHeisenberg.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("LogMailer.Send() wrote %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "\nBcc:") {
+		t.Error("LogMailer.Send() let a header be injected through the recipient")
+	}
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	if _, err := NewSMTPMailer("localhost", "chirpy@example.com", "", ""); err == nil {
+		t.Error("NewSMTPMailer() accepted an address without a port")
+	}
+	if _, err := NewSMTPMailer("localhost:25", "", "", ""); err == nil {
+		t.Error("NewSMTPMailer() accepted an empty sender")
+	}
+	m, err := NewSMTPMailer("localhost:25", "chirpy@example.com", "user", "pass")
+	if err != nil || m.auth == nil {
+		t.Errorf("NewSMTPMailer() = %+v, %v", m, err)
+	}
+}
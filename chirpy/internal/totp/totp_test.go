@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors from RFC 6238 appendix B, truncated to 6 digits
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1_700_000_000, 0)
+	code, _ := Code(secret, Step(at)-1)
+
+	if step, ok := Validate(secret, code, at, 1); !ok || step != Step(at)-1 {
+		t.Errorf("Validate() previous step = %d, %v; want %d, true", step, ok, Step(at)-1)
+	}
+	if _, ok := Validate(secret, code, at.Add(2*Period), 1); ok {
+		t.Error("Validate() accepted a code from three steps ago")
+	}
+	if _, ok := Validate(secret, "000000x", at, 1); ok {
+		t.Error("Validate() accepted a malformed code")
+	}
+	if _, ok := Validate("not base32!", code, at, 1); ok {
+		t.Error("Validate() accepted a code for a malformed secret")
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Chirpy", "walt@breakingbad.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Chirpy:walt@breakingbad.com" {
+		t.Errorf("URI() = %s", u)
+	}
+	if q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "Chirpy" || q.Get("digits") != "6" {
+		t.Errorf("URI() query = %v", q)
+	}
+}
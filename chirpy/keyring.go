@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// hmacKeyID names the JWT_SECRET key. Tokens signed before key IDs were
+// added carry no kid, and are checked against it too.
+const hmacKeyID = "hs256"
+
+// jwtKey is one key on the keyring. Keys loaded from a public key file
+// can only verify; they are kept so tokens signed by a retired key stay
+// valid until they expire.
+type jwtKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   any // private key or HMAC secret; nil if verify-only
+	verify any // public key or HMAC secret
+}
+
+// keyring holds the keys tokens are signed and verified with.
+//
+// Keys are read from PEM files in a directory, one key per file, named
+// <kid>.pem. Private keys (RSA or Ed25519, PKCS#8 or PKCS#1) sign and
+// verify; public keys only verify. The private key whose kid sorts last
+// signs new tokens, so naming keys by date rotates them: add a newer key,
+// restart, and once the old key's tokens have expired remove it. e.g.
+//
+//	openssl genpkey -algorithm ed25519 -out keys/2024-05-01.pem
+//
+// JWT_SECRET, if set, is kept as an HS256 key. It signs only when the
+// directory holds no private key.
+type keyring struct {
+	signer *jwtKey
+	keys   map[string]*jwtKey
+}
+
+// newKeyring loads the keys in dir, which may be empty, and secret
+func newKeyring(dir, secret string) (*keyring, error) {
+	kr := &keyring{keys: map[string]*jwtKey{}}
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(paths)
+		for _, path := range paths {
+			key, err := loadKey(path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			kr.keys[key.id] = key
+			if key.sign != nil {
+				kr.signer = key
+			}
+		}
+	}
+
+	if secret != "" {
+		key := &jwtKey{
+			id:     hmacKeyID,
+			method: jwt.SigningMethodHS256,
+			sign:   []byte(secret),
+			verify: []byte(secret),
+		}
+		kr.keys[key.id] = key
+		if kr.signer == nil {
+			kr.signer = key
+		}
+	}
+
+	if kr.signer == nil {
+		return nil, errors.New("no signing key: set JWT_SECRET or add a private key to JWT_KEY_DIR")
+	}
+	return kr, nil
+}
+
+func loadKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	key := &jwtKey{id: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.sign, key.verify = jwt.SigningMethodRS256, k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.method, key.sign, key.verify = jwt.SigningMethodEdDSA, k, k.Public()
+	case *rsa.PublicKey:
+		key.method, key.verify = jwt.SigningMethodRS256, k
+	case ed25519.PublicKey:
+		key.method, key.verify = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+// sign signs claims with the current signing key
+func (kr *keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.signer.method, claims)
+	token.Header["kid"] = kr.signer.id
+	return token.SignedString(kr.signer.sign)
+}
+
+// parse verifies tokenString with the key named by its kid and decodes
+// its claims. The token's alg must be the one that key is used with.
+func (kr *keyring) parse(
+	tokenString string,
+	claims jwt.Claims,
+	opts ...jwt.ParserOption,
+) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(
+		tokenString,
+		claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			if kid == "" {
+				kid = hmacKeyID
+			}
+			key, ok := kr.keys[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown key %q", kid)
+			}
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("key %q is not used with %s", kid, token.Method.Alg())
+			}
+			return key.verify, nil
+		},
+		opts...,
+	)
+}
+
+// jwk is a public key in JSON Web Key form (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// publicKeys returns the asymmetric keys as JWKs, sorted by kid.
+// The HMAC secret is never published.
+func (kr *keyring) publicKeys() []jwk {
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys := []jwk{}
+	for _, key := range kr.keys {
+		k := jwk{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.verify.(type) {
+		case *rsa.PublicKey:
+			k.Kty = "RSA"
+			k.N = b64(pub.N.Bytes())
+			k.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			k.Kty = "OKP"
+			k.Crv = "Ed25519"
+			k.X = b64(pub)
+		default:
+			continue
+		}
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b jwk) int {
+		return strings.Compare(a.Kid, b.Kid)
+	})
+	return keys
+}
+
+// getJWKS serves the verification keys so other services can check
+// Chirpy tokens without holding a secret
+func (cfg *apiConfig) getJWKS(w http.ResponseWriter, _ *http.Request) {
+	type jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, jwks{Keys: cfg.keys.publicKeys()})
+}
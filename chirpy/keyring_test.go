@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupKeyDir writes a retired RSA key that can only verify, an older
+// Ed25519 private key and a newer RSA private key
+func setupKeyDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	retired, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&retired.PublicKey)
+	writePEM(t, filepath.Join(dir, "2024-01-01.pem"), "PUBLIC KEY", der)
+
+	_, older, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ = x509.MarshalPKCS8PrivateKey(older)
+	writePEM(t, filepath.Join(dir, "2024-03-01.pem"), "PRIVATE KEY", der)
+
+	newer, _ := rsa.GenerateKey(rand.Reader, 2048)
+	writePEM(t, filepath.Join(dir, "2024-05-01.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(newer))
+
+	return dir
+}
+
+func Test_keyring(t *testing.T) {
+	kr, err := newKeyring(setupKeyDir(t), "sausages")
+	if err != nil {
+		t.Fatalf("newKeyring() error = %v", err)
+	}
+
+	// the newest private key signs
+	signed, err := kr.sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatalf("keyring.sign() error = %v", err)
+	}
+	token, err := kr.parse(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("keyring.parse() error = %v", err)
+	}
+	if token.Header["kid"] != "2024-05-01" || token.Method.Alg() != "RS256" {
+		t.Errorf("signed with %v/%s, want 2024-05-01/RS256", token.Header["kid"], token.Method.Alg())
+	}
+
+	// tokens from the older key and the shared secret still verify
+	older := &keyring{signer: kr.keys["2024-03-01"], keys: kr.keys}
+	signed, _ = older.sign(jwt.RegisteredClaims{Subject: "1"})
+	if _, err := kr.parse(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("keyring.parse() older key error = %v", err)
+	}
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	signed, _ = legacy.SignedString([]byte("sausages"))
+	if _, err := kr.parse(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("keyring.parse() token without kid error = %v", err)
+	}
+
+	// a token whose alg doesn't match its key is refused, even when the
+	// key material would verify it
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	forged.Header["kid"] = "2024-05-01"
+	signed, _ = forged.SignedString([]byte("sausages"))
+	if _, err := kr.parse(signed, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("keyring.parse() accepted an HS256 token for an RSA key")
+	}
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	unknown.Header["kid"] = "nope"
+	signed, _ = unknown.SignedString([]byte("sausages"))
+	if _, err := kr.parse(signed, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("keyring.parse() accepted an unknown kid")
+	}
+}
+
+func Test_newKeyringWithoutKeys(t *testing.T) {
+	if _, err := newKeyring(t.TempDir(), ""); err == nil {
+		t.Error("newKeyring() with no keys at all succeeded")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0600)
+	if _, err := newKeyring(dir, "sausages"); err == nil {
+		t.Error("newKeyring() accepted a broken key file")
+	}
+}
+
+func Test_getJWKS(t *testing.T) {
+	kr, err := newKeyring(setupKeyDir(t), "sausages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := apiConfig{keys: kr}
+
+	rec := httptest.NewRecorder()
+	cfg.getJWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	resp := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not decode jwks: %s", err)
+	}
+
+	// every asymmetric key, but never the shared secret
+	want := []struct{ kid, kty, alg string }{
+		{"2024-01-01", "RSA", "RS256"},
+		{"2024-03-01", "OKP", "EdDSA"},
+		{"2024-05-01", "RSA", "RS256"},
+	}
+	if len(resp.Keys) != len(want) {
+		t.Fatalf("getJWKS() returned %d keys, want %d", len(resp.Keys), len(want))
+	}
+	for i, w := range want {
+		k := resp.Keys[i]
+		if k.Kid != w.kid || k.Kty != w.kty || k.Alg != w.alg {
+			t.Errorf("key %d = %s/%s/%s, want %s/%s/%s", i, k.Kid, k.Kty, k.Alg, w.kid, w.kty, w.alg)
+		}
+		if k.Kty == "RSA" && (k.N == "" || k.E != "AQAB") {
+			t.Errorf("RSA key %s has n=%q e=%q", k.Kid, k.N, k.E)
+		}
+		if k.Kty == "OKP" && (k.Crv != "Ed25519" || k.X == "") {
+			t.Errorf("Ed25519 key %s has crv=%q x=%q", k.Kid, k.Crv, k.X)
+		}
+	}
+}
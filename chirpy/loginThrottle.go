@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed logins are counted per account, which stops guessing one
+// user's password, and per client address, which stops trying a few
+// common passwords against many accounts. An address gets more tries
+// since several people may share it.
+var (
+	accountLockout = database.LockoutPolicy{
+		Threshold: 5,
+		Base:      30 * time.Second,
+		Max:       time.Hour,
+		Reset:     time.Hour,
+	}
+	ipLockout = database.LockoutPolicy{
+		Threshold: 20,
+		Base:      time.Minute,
+		Max:       time.Hour,
+		Reset:     time.Hour,
+	}
+)
+
+func accountKey(email string) string {
+	return "account:" + email
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginLockedFor returns how long logins to email from r are refused
+// for; zero if they aren't
+func (cfg *apiConfig) loginLockedFor(r *http.Request, email string) (time.Duration, error) {
+	at := time.Now()
+	wait := time.Duration(0)
+	for _, key := range []string{accountKey(email), ipKey(clientIP(r))} {
+		lt, err := cfg.userDB.GetLoginThrottle(key)
+		if err != nil {
+			return 0, err
+		}
+		if lt.Locked(at) {
+			wait = max(wait, lt.LockedUntil.Sub(at))
+		}
+	}
+	return wait, nil
+}
+
+// loginFailed counts a failed login to email from r. Failures only count
+// against the account if email is registered; otherwise trying made-up
+// addresses would store a throttle for each of them.
+func (cfg *apiConfig) loginFailed(r *http.Request, email string) {
+	if _, err := cfg.userDB.GetUserId(email); err == nil {
+		lt, err := cfg.userDB.RecordLoginFailure(accountKey(email), accountLockout)
+		if err != nil {
+			log.Printf("Could not record failed login: %s", err)
+		} else if lt.Failures == accountLockout.Threshold {
+			log.Printf("Locked out %s after %d failed logins", email, lt.Failures)
+		}
+	}
+	ip := clientIP(r)
+	lt, err := cfg.userDB.RecordLoginFailure(ipKey(ip), ipLockout)
+	if err != nil {
+		log.Printf("Could not record failed login: %s", err)
+	} else if lt.Failures == ipLockout.Threshold {
+		log.Printf("Locked out %s after %d failed logins", ip, lt.Failures)
+	}
+}
+
+// confirmPassword checks that password is user's, for actions that need
+// it on top of an access token, and responds with an error if it isn't.
+// Wrong passwords count as failed logins, so they can't be guessed this
+// way either. It reports whether the password was confirmed.
+func (cfg *apiConfig) confirmPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+	user database.User,
+	password string,
+) bool {
+	if cfg.refuseLogin(w, r, user.Email) {
+		return false
+	}
+	hash, err := cfg.userDB.GetUserPassword(user.Id)
+	if err != nil {
+		log.Printf("Could not look up user: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		cfg.loginFailed(r, user.Email)
+		respondWithError(w, http.StatusUnauthorized, "Password incorrect")
+		return false
+	}
+	return true
+}
+
+// refuseLogin checks whether logins to email from r are locked out and
+// if so responds with 429. It reports whether it responded.
+func (cfg *apiConfig) refuseLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	email string,
+) bool {
+	wait, err := cfg.loginLockedFor(r, email)
+	if err != nil {
+		log.Printf("Could not check login throttle: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed logins")
+	return true
+}
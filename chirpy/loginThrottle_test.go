@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_loginLockout(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	verifyUser(t, cfg, "walt@breakingbad.com")
+	if err := bootstrapAdmin(cfg.userDB, "walt@breakingbad.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.userDB.AddUser("jesse@breakingbad.com", "abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	walt := login(t, cfg, "laptop")
+
+	try := func(email, password, addr string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body))
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		cfg.loginUser(rec, req)
+		return rec
+	}
+
+	for i := 0; i < accountLockout.Threshold; i++ {
+		if rec := try("jesse@breakingbad.com", "wrong", "10.0.0.1:1234"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d = %d, want 401", i+1, rec.Code)
+		}
+	}
+	// the right password from another address is still refused
+	rec := try("jesse@breakingbad.com", "abcdef", "10.0.0.2:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("login while locked = %d, want 429", rec.Code)
+	}
+	retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || retry <= 0 || retry > int(accountLockout.Base.Seconds()) {
+		t.Errorf("Retry-After = %q, want 1..%d", rec.Header().Get("Retry-After"), int(accountLockout.Base.Seconds()))
+	}
+	// other accounts are unaffected
+	if rec := try("walt@breakingbad.com", "123456", "10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("login to another account = %d, want 200", rec.Code)
+	}
+
+	// an admin can lift the lock
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/2/unlock", nil)
+	req.Header.Set("Authorization", "Bearer "+walt.AccessToken)
+	req.SetPathValue("ID", "2")
+	unlock := httptest.NewRecorder()
+	cfg.middlewareRole(database.RoleAdmin, cfg.unlockUser)(unlock, req)
+	if unlock.Code != http.StatusOK {
+		t.Fatalf("unlockUser = %d, want 200", unlock.Code)
+	}
+	if rec := try("jesse@breakingbad.com", "abcdef", "10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("login after unlock = %d, want 200", rec.Code)
+	}
+
+	// an address spraying many accounts is locked out too
+	for i := 0; i < ipLockout.Threshold; i++ {
+		email := "user" + strconv.Itoa(i) + "@example.com"
+		if rec := try(email, "wrong", "10.0.0.3:1234"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d = %d, want 401", i+1, rec.Code)
+		}
+	}
+	if rec := try("walt@breakingbad.com", "123456", "10.0.0.3:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("login from a locked address = %d, want 429", rec.Code)
+	}
+	// but unregistered emails don't get a throttle of their own
+	if lt, _ := cfg.userDB.GetLoginThrottle(accountKey("user0@example.com")); lt.Failures != 0 {
+		t.Errorf("throttle for an unregistered email = %+v, want none", lt)
+	}
+}
@@ -2,48 +2,122 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/jsMRSoL/avian-din/internal/database"
+	"github.com/jsMRSoL/avian-din/internal/mail"
 )
 
 func main() {
 
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	adminEmail := flag.String(
+		"admin",
+		"",
+		"Make the verified user with this email an admin if there is none yet (or set CHIRPY_ADMIN_EMAIL)",
+	)
+	requireVerified := flag.String(
+		"require-verified",
+		"post",
+		"Actions that need a verified email: none, or some of post,delete",
+	)
+	deletedChirps := flag.String(
+		"deleted-chirps",
+		"delete",
+		"What happens to the chirps of deleted accounts: delete or anonymize",
+	)
+	backend := flag.String("backend", "json", "Storage backend: json or sqlite")
+	backups := flag.Int("backups", 3, "Backup generations kept by the json backend")
+	reloadInterval := flag.Duration(
+		"reload-interval",
+		0,
+		"Poll the json files for outside changes this often (0 disables)",
+	)
+	expiryInterval := flag.Duration(
+		"expiry-interval",
+		time.Hour,
+		"Expire lapsed subscriptions and prune expired refresh tokens this often (0 disables)",
+	)
 	flag.Parse()
 	if *dbg == true {
 		log.Println("In debug mode.................")
 		os.Remove("storage.db")
+		os.Remove("storage.db.log")
 		os.Remove("users.db")
+		os.Remove("users.db.log")
+		os.Remove("chirpy.sqlite3")
+		os.Remove("chirpy.sqlite3-wal")
+		os.Remove("chirpy.sqlite3-shm")
 	}
 
-	path := "storage.db"
-	chirpsDB, err := database.NewDB(path)
+	chirpsDB, userDB, err := openStores(*backend, database.WithBackups(*backups))
 	if err != nil {
 		log.Printf("Error creating DB: %s", err)
 		return
 	}
+	watchStores(*reloadInterval, chirpsDB, userDB)
+	runExpiry(*expiryInterval, userDB)
 
-	userDB_path := "users.db"
-	userDB, err := database.NewUserDB(userDB_path)
+	/// Get env variable
+	godotenv.Load()
+	jwtSecret := os.Getenv("JWT_SECRET")
+	// POLKA_APIKEY was the plain key Polka used to send; it is the
+	// signing secret now unless POLKA_WEBHOOK_SECRET is set
+	polkaSecret := os.Getenv("POLKA_WEBHOOK_SECRET")
+	if polkaSecret == "" {
+		polkaSecret = os.Getenv("POLKA_APIKEY")
+	}
+	if polkaSecret == "" {
+		log.Println("POLKA_WEBHOOK_SECRET is not set; Polka webhooks will be refused")
+	}
+	if *adminEmail == "" {
+		*adminEmail = os.Getenv("CHIRPY_ADMIN_EMAIL")
+	}
+	if *adminEmail != "" {
+		if err := bootstrapAdmin(userDB, *adminEmail); err != nil {
+			log.Printf("Could not make %s an admin: %s", *adminEmail, err)
+		}
+	}
+
+	keys, err := newKeyring(os.Getenv("JWT_KEY_DIR"), jwtSecret)
 	if err != nil {
-		log.Printf("Error creating DB: %s", err)
+		log.Printf("Error loading JWT keys: %s", err)
 		return
 	}
 
-	/// Get env variable
-	godotenv.Load()
-	jwtSecret := os.Getenv("JWT_SECRET")
-	polkaApikey := os.Getenv("POLKA_APIKEY")
+	policy, err := parseVerificationPolicy(*requireVerified)
+	if err != nil {
+		log.Printf("Error in -require-verified: %s", err)
+		return
+	}
+
+	chirpPolicy, err := parseChirpPolicy(*deletedChirps)
+	if err != nil {
+		log.Printf("Error in -deleted-chirps: %s", err)
+		return
+	}
+
+	mailer, err := openMailer()
+	if err != nil {
+		log.Printf("Error setting up mail: %s", err)
+		return
+	}
 
 	apiConfig := apiConfig{
-		chirpsDB:    chirpsDB,
-		userDB:      userDB,
-		secret:      jwtSecret,
-		polkaApikey: polkaApikey,
+		chirpsDB:        chirpsDB,
+		userDB:          userDB,
+		keys:            keys,
+		mailer:          mailer,
+		requireVerified: policy,
+		deletedChirps:   chirpPolicy,
+		polkaSecret:     polkaSecret,
 	}
 
 	mux := http.NewServeMux()
@@ -52,21 +126,68 @@ func main() {
 	fsHandle := http.StripPrefix("/app/", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/*", apiConfig.middlewareMetricsInc(fsHandle))
 
-	mux.HandleFunc("POST /api/users", apiConfig.addUser)
-	mux.HandleFunc("PUT /api/users", apiConfig.updateUser)
-	mux.HandleFunc("POST /api/login", apiConfig.loginUser)
+	// routes that are cheap to flood are rate limited per client: by
+	// address on anonymous routes and by user on authenticated ones
+	limit := apiConfig.middlewareRateLimit
+	signupLimit := ratePolicy{Limit: 5, Window: time.Hour}
+	mailLimit := ratePolicy{Limit: 3, Window: time.Hour}
+	loginLimit := ratePolicy{Limit: 10, Window: time.Minute}
+	chirpLimit := ratePolicy{Limit: 10, RedLimit: 60, Window: time.Minute}
+	webhookLimit := ratePolicy{Limit: 60, Window: time.Minute}
+	exportLimit := ratePolicy{Limit: 5, Window: time.Hour}
+
+	// anonymous routes; refresh and revoke check the refresh token
+	// themselves
+	mux.HandleFunc("POST /api/users", limit(signupLimit, apiConfig.addUser))
+	mux.HandleFunc("POST /api/login", limit(loginLimit, apiConfig.loginUser))
+	mux.HandleFunc("POST /api/login/2fa", limit(loginLimit, apiConfig.loginTwoFactor))
 	mux.HandleFunc("POST /api/refresh", apiConfig.refreshAccessToken)
 	mux.HandleFunc("POST /api/revoke", apiConfig.revokeRefreshToken)
+	mux.HandleFunc("POST /api/password/forgot", limit(mailLimit, apiConfig.forgotPassword))
+	mux.HandleFunc("POST /api/password/reset", apiConfig.resetPassword)
+	mux.HandleFunc("POST /api/users/verify", apiConfig.verifyEmail)
+	mux.HandleFunc("GET /api/users/{handle}", apiConfig.getProfile)
 	mux.HandleFunc("GET /api/chirps", apiConfig.getChirps)
-	mux.HandleFunc("POST /api/chirps", apiConfig.postChirp)
-	mux.HandleFunc("DELETE /api/chirps/{ID}", apiConfig.deleteChirp)
 	mux.HandleFunc("GET /api/chirps/{ID}", apiConfig.getChirpByID)
 
-	mux.HandleFunc("POST /api/polka/webhooks", apiConfig.upgradeUser)
+	// authenticated routes need a valid access token
+	auth := apiConfig.middlewareAuth
+	mux.HandleFunc("PATCH /api/users/me", auth(apiConfig.updateUser))
+	mux.HandleFunc("PUT /api/users", auth(apiConfig.updateUser))
+	mux.HandleFunc("DELETE /api/users/me", auth(apiConfig.deleteUser))
+	mux.HandleFunc("GET /api/users/me/export", auth(limit(exportLimit, apiConfig.exportUser)))
+	mux.HandleFunc("POST /api/users/verify/resend", auth(limit(mailLimit, apiConfig.resendVerification)))
+	mux.HandleFunc("GET /api/sessions", auth(apiConfig.getSessions))
+	mux.HandleFunc("DELETE /api/sessions/{ID}", auth(apiConfig.deleteSession))
+	mux.HandleFunc("POST /api/logout-all", auth(apiConfig.logoutAll))
+	mux.HandleFunc("POST /api/2fa/enroll", auth(apiConfig.enrollTwoFactor))
+	mux.HandleFunc("POST /api/2fa/confirm", auth(apiConfig.confirmTwoFactor))
+	mux.HandleFunc("POST /api/2fa/disable", auth(apiConfig.disableTwoFactor))
+
+	// and so do these, and a verified email if -require-verified says so
+	verified := func(action string, next http.HandlerFunc) http.HandlerFunc {
+		return auth(apiConfig.middlewareVerified(action, next))
+	}
+	mux.HandleFunc("POST /api/chirps", verified("post", limit(chirpLimit, apiConfig.postChirp)))
+	mux.HandleFunc("DELETE /api/chirps/{ID}", verified("delete", apiConfig.deleteChirp))
+
+	// moderator and admin routes also need the role
+	moderator := func(next http.HandlerFunc) http.HandlerFunc {
+		return apiConfig.middlewareRole(database.RoleModerator, next)
+	}
+	admin := func(next http.HandlerFunc) http.HandlerFunc {
+		return apiConfig.middlewareRole(database.RoleAdmin, next)
+	}
+	mux.HandleFunc("DELETE /admin/chirps/{ID}", moderator(apiConfig.moderateChirp))
+	mux.HandleFunc("PUT /admin/users/{ID}/role", admin(apiConfig.setUserRole))
+	mux.HandleFunc("POST /admin/users/{ID}/unlock", admin(apiConfig.unlockUser))
+	mux.HandleFunc("GET /admin/metrics", admin(apiConfig.getFsHits))
+	mux.HandleFunc("/api/reset", admin(apiConfig.resetFsHits))
+
+	mux.HandleFunc("POST /api/polka/webhooks", limit(webhookLimit, apiConfig.upgradeUser))
 
 	mux.HandleFunc("GET /api/healthz", healthEndPoint)
-	mux.HandleFunc("GET /admin/metrics", apiConfig.getFsHits)
-	mux.HandleFunc("/api/reset", apiConfig.resetFsHits)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiConfig.getJWKS)
 
 	corsMux := middlewareCors(mux)
 	port := ":8080"
@@ -78,3 +199,120 @@ func main() {
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+func openStores(
+	backend string,
+	opts ...database.Option,
+) (database.ChirpStore, database.UserStore, error) {
+	switch backend {
+	case "json":
+		chirpsDB, err := database.NewDB("storage.db", opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		userDB, err := database.NewUserDB("users.db", opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return chirpsDB, userDB, nil
+	case "sqlite":
+		db, err := database.NewSQLiteDB("chirpy.sqlite3")
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, db, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// openMailer sends mail through SMTP_ADDR if it is set. Otherwise mail is
+// written to MAIL_FILE, or to the log if that isn't set either.
+func openMailer() (mail.Mailer, error) {
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return mail.NewSMTPMailer(
+			addr,
+			os.Getenv("MAIL_FROM"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		)
+	}
+	if path := os.Getenv("MAIL_FILE"); path != "" {
+		return mail.NewFileMailer(path)
+	}
+	return mail.NewLogMailer(log.Writer()), nil
+}
+
+// reloader is implemented by stores that keep their data in memory
+type reloader interface {
+	Reload() error
+	Watch(interval time.Duration) (stop func())
+}
+
+// watchStores makes in-memory stores pick up changes that another process
+// made to their files: on SIGHUP, and by polling when interval is non-zero
+func watchStores(interval time.Duration, stores ...any) {
+	reloaders := []reloader{}
+	for _, store := range stores {
+		if r, ok := store.(reloader); ok {
+			reloaders = append(reloaders, r)
+		}
+	}
+	if len(reloaders) == 0 {
+		return
+	}
+
+	if interval > 0 {
+		for _, r := range reloaders {
+			r.Watch(interval)
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("SIGHUP received, reloading stores")
+			for _, r := range reloaders {
+				if err := r.Reload(); err != nil {
+					log.Printf("Error reloading DB: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// runExpiry expires lapsed Chirpy Red subscriptions and prunes expired
+// refresh tokens and login throttles now and then every interval. Users
+// stop being Red once their period ends whether or not this has run; it
+// moves the subscriptions on so they show as expired, and keeps the
+// tokens and throttles from piling up.
+func runExpiry(interval time.Duration, userDB database.UserStore) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			n, err := userDB.ExpireSubscriptions()
+			if err != nil {
+				log.Printf("Error expiring subscriptions: %s", err)
+			} else if n > 0 {
+				log.Printf("Expired %d subscriptions", n)
+			}
+			n, err = userDB.PruneRefreshTokens()
+			if err != nil {
+				log.Printf("Error pruning refresh tokens: %s", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d refresh tokens", n)
+			}
+			n, err = userDB.PruneLoginThrottles()
+			if err != nil {
+				log.Printf("Error pruning login throttles: %s", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d login throttles", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+// principal is who an authenticated request was made by
+type principal struct {
+	UserID    int
+	SessionID string
+	Role      database.Role
+}
+
+type principalKey struct{}
+
+// middlewareAuth lets a request through to next only if it carries a valid
+// access token, and puts the principal it names in the request context.
+// Routes wrapped in it are the authenticated ones; see main.go.
+func (cfg *apiConfig) middlewareAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondWithError(w, http.StatusUnauthorized, "Token invalid/expired")
+			return
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, p)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// middlewareRole is middlewareAuth for routes that also need role: the
+// request is refused with 403 unless the principal's role includes it
+func (cfg *apiConfig) middlewareRole(
+	role database.Role,
+	next http.HandlerFunc,
+) http.HandlerFunc {
+	return cfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !requestPrincipal(r).Role.Includes(role) {
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// requestPrincipal returns the principal middlewareAuth found for r. It
+// panics if the route wasn't wrapped in middlewareAuth, which is a bug in
+// how the route was declared rather than something a client can cause.
+func requestPrincipal(r *http.Request) principal {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	if !ok {
+		panic("requestPrincipal: " + r.URL.Path + " is not an authenticated route")
+	}
+	return p
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_middlewareAuth(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	user := login(t, cfg, "phone")
+	session, _ := cfg.userDB.GetSessions(user.Id)
+
+	// sign builds an access token for walt's session, changed by edit
+	sign := func(edit func(*accessClaims)) string {
+		t.Helper()
+		claims := accessClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				Issuer:    accessTokenIssuer,
+				Audience:  jwt.ClaimStrings{accessTokenAudience},
+				Subject:   "1",
+			},
+			SessionID: session[0].ID,
+		}
+		edit(&claims)
+		token, err := cfg.keys.sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{
+			name:     "token from login",
+			token:    user.AccessToken,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no token",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "refresh token",
+			token:    user.RefreshToken,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "other issuer",
+			token:    sign(func(c *accessClaims) { c.Issuer = "chirpy-refresh" }),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "other audience",
+			token: sign(func(c *accessClaims) {
+				c.Audience = jwt.ClaimStrings{"somewhere-else"}
+			}),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "no expiry",
+			token:    sign(func(c *accessClaims) { c.ExpiresAt = nil }),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "subject not a user ID",
+			token:    sign(func(c *accessClaims) { c.Subject = "walt" }),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "someone else's session",
+			token:    sign(func(c *accessClaims) { c.Subject = "2" }),
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *principal
+			handler := cfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+				p := requestPrincipal(r)
+				got = &p
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("middlewareAuth() = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				if got != nil {
+					t.Error("middlewareAuth() called the handler")
+				}
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("middlewareAuth() set no WWW-Authenticate header")
+				}
+				return
+			}
+			want := principal{
+				UserID:    user.Id,
+				SessionID: session[0].ID,
+				Role:      database.RoleUser,
+			}
+			if got == nil || *got != want {
+				t.Errorf("principal = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// chirpsPageResponse is the body of a paginated GET /api/chirps.
+// Cursors are opaque to clients: pass next_cursor back as after.
+type chirpsPageResponse struct {
+	Chirps     []chirpResponse `json:"chirps"`
+	NextCursor *string         `json:"next_cursor"`
+	PrevCursor *string         `json:"prev_cursor"`
+}
+
+func isPageRequest(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Has("limit") || query.Has("after") || query.Has("before")
+}
+
+func encodeCursor(chirp database.Chirp) string {
+	dat, _ := json.Marshal(database.ChirpCursor{
+		Id:        chirp.Id,
+		CreatedAt: chirp.CreatedAt,
+	})
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeCursor(s string) (*database.ChirpCursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	cursor := database.ChirpCursor{}
+	if err := json.Unmarshal(dat, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Id <= 0 {
+		return nil, errors.New("cursor has no position")
+	}
+	return &cursor, nil
+}
+
+// chirpsPage answers GET /api/chirps when the client asks for a page
+// with any of limit, after or before. q holds the filters and sort order
+// already read from the request.
+func (cfg *apiConfig) chirpsPage(
+	w http.ResponseWriter,
+	r *http.Request,
+	q database.ChirpQuery,
+) {
+	query := r.URL.Query()
+	q.Limit = defaultPageSize
+
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			respondWithError(
+				w,
+				http.StatusBadRequest,
+				fmt.Sprintf("limit must be between 1 and %d", maxPageSize),
+			)
+			return
+		}
+		q.Limit = limit
+	}
+
+	var err error
+	if s := query.Get("after"); s != "" {
+		if q.After, err = decodeCursor(s); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid after cursor")
+			return
+		}
+	}
+	if s := query.Get("before"); s != "" {
+		if q.Before, err = decodeCursor(s); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid before cursor")
+			return
+		}
+	}
+
+	page, err := cfg.chirpsDB.ChirpsPage(q)
+	if err != nil {
+		log.Printf("Could not retrieve chirps page: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resp := chirpsPageResponse{Chirps: cfg.chirpResponses(r, page.Chirps)}
+	links := []string{}
+	if n := len(page.Chirps); n > 0 {
+		// a page read backwards always has its cursor's chirp after it
+		backwards := q.Before != nil && q.After == nil
+		if page.HasMore || backwards {
+			next := encodeCursor(page.Chirps[n-1])
+			resp.NextCursor = &next
+			links = append(links, pageLink(r, "after", next, "next"))
+		}
+		if (backwards && page.HasMore) || (!backwards && q.After != nil) {
+			prev := encodeCursor(page.Chirps[0])
+			resp.PrevCursor = &prev
+			links = append(links, pageLink(r, "before", prev, "prev"))
+		}
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// pageLink builds an RFC 8288 link to the page on the other side of cursor,
+// keeping the rest of the request's query
+func pageLink(r *http.Request, param, cursor, rel string) string {
+	query := r.URL.Query()
+	query.Del("after")
+	query.Del("before")
+	query.Set(param, cursor)
+	u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf("<%s>; rel=\"%s\"", u.String(), rel)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+)
+
+func Test_getChirpsPaginated(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		db.StoreChirp("chirp", 1)
+	}
+	cfg := apiConfig{chirpsDB: db}
+
+	get := func(url string) (chirpsPageResponse, http.Header) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		cfg.getChirps(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s code = %d, want 200", url, rec.Code)
+		}
+		resp := chirpsPageResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("could not decode response: %s", err)
+		}
+		return resp, rec.Header()
+	}
+	nextLink := regexp.MustCompile(`<([^>]+)>; rel="next"`)
+
+	// walk every page of the descending list by following the Link header
+	url := "/api/chirps?sort=desc&limit=2"
+	ids := []int{}
+	for pages := 0; url != ""; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		resp, header := get(url)
+		for _, ch := range resp.Chirps {
+			ids = append(ids, ch.Id)
+		}
+
+		url = ""
+		if m := nextLink.FindStringSubmatch(header.Get("Link")); m != nil {
+			url = m[1]
+			if resp.NextCursor == nil {
+				t.Error("Link has a next page but next_cursor is null")
+			}
+		}
+	}
+	if want := []int{5, 4, 3, 2, 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("paged ids = %v, want %v", ids, want)
+	}
+
+	for _, url := range []string{
+		"/api/chirps?limit=0",
+		"/api/chirps?limit=1000",
+		"/api/chirps?after=not-a-cursor",
+	} {
+		rec := httptest.NewRecorder()
+		cfg.getChirps(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s code = %d, want 400", url, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ratePolicy is how often one client may call a route: Limit requests
+// per Window, of which all Limit may come at once. Chirpy Red users get
+// RedLimit instead if it is set.
+type ratePolicy struct {
+	Limit    int
+	RedLimit int
+	Window   time.Duration
+}
+
+// bucket is a token bucket: tokens it held at last, refilled since
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds the buckets for one route, keyed by client
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// take spends a token from key's bucket, which holds up to limit and
+// refills completely in window. It reports whether there was one, how
+// many are left, and how long until the bucket is full again or, if it
+// was empty, until the next token.
+func (rl *rateLimiter) take(
+	key string,
+	limit int,
+	window time.Duration,
+) (ok bool, remaining int, wait time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	at := rl.now()
+	perToken := window / time.Duration(limit)
+	rl.sweep(at, window)
+
+	b, found := rl.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(limit), last: at}
+		rl.buckets[key] = b
+	}
+	b.tokens = min(float64(limit), b.tokens+float64(at.Sub(b.last))/float64(perToken))
+	b.last = at
+
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	missing := float64(limit) - b.tokens
+	return true, int(b.tokens), time.Duration(missing * float64(perToken))
+}
+
+// sweep drops buckets that have had time to refill, since a fresh one
+// is the same; it runs at most once a window
+func (rl *rateLimiter) sweep(at time.Time, window time.Duration) {
+	if at.Sub(rl.lastSweep) < window {
+		return
+	}
+	for key, b := range rl.buckets {
+		if at.Sub(b.last) >= window {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = at
+}
+
+// middlewareRateLimit refuses requests to next with 429 once a client
+// has used up policy. Clients are the authenticated user if the route is
+// wrapped in middlewareAuth, and the client address if not. Every
+// response carries RateLimit-* headers describing the client's quota.
+func (cfg *apiConfig) middlewareRateLimit(
+	policy ratePolicy,
+	next http.HandlerFunc,
+) http.HandlerFunc {
+	rl := newRateLimiter()
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + clientIP(r)
+		limit := policy.Limit
+		if p, ok := r.Context().Value(principalKey{}).(principal); ok {
+			key = fmt.Sprintf("user:%d", p.UserID)
+			if policy.RedLimit > 0 {
+				user, err := cfg.userDB.GetUser(p.UserID)
+				if err != nil {
+					log.Printf("Could not look up user %d: %s", p.UserID, err)
+				} else if user.IsChirpyRed {
+					// a separate bucket, so upgrading doesn't carry
+					// over the free one's size
+					key = "red:" + key
+					limit = policy.RedLimit
+				}
+			}
+		}
+
+		ok, remaining, wait := rl.take(key, limit, policy.Window)
+		seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+		w.Header().Set(
+			"RateLimit-Policy",
+			fmt.Sprintf("%d;w=%d", limit, int(policy.Window.Seconds())),
+		)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", seconds)
+		if !ok {
+			w.Header().Set("Retry-After", seconds)
+			respondWithError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter_take(t *testing.T) {
+	rl := newRateLimiter()
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rl.now = func() time.Time { return at }
+
+	for i := 2; i >= 0; i-- {
+		ok, remaining, _ := rl.take("a", 3, time.Minute)
+		if !ok || remaining != i {
+			t.Fatalf("take() = %v, %d; want true, %d", ok, remaining, i)
+		}
+	}
+	ok, _, wait := rl.take("a", 3, time.Minute)
+	if ok || wait != 20*time.Second {
+		t.Errorf("take() on an empty bucket = %v, %s; want false, 20s", ok, wait)
+	}
+	if ok, _, _ := rl.take("b", 3, time.Minute); !ok {
+		t.Error("take() for another key was refused")
+	}
+
+	at = at.Add(20 * time.Second)
+	if ok, remaining, _ := rl.take("a", 3, time.Minute); !ok || remaining != 0 {
+		t.Errorf("take() after refilling one token = %v, %d; want true, 0", ok, remaining)
+	}
+
+	at = at.Add(time.Hour)
+	rl.take("c", 3, time.Minute)
+	if len(rl.buckets) != 1 {
+		t.Errorf("%d buckets after a sweep, want 1", len(rl.buckets))
+	}
+}
+
+func Test_middlewareRateLimit(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	walt := login(t, cfg, "laptop")
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	policy := ratePolicy{Limit: 2, RedLimit: 4, Window: time.Minute}
+	anon := cfg.middlewareRateLimit(policy, ok)
+	authed := cfg.middlewareAuth(cfg.middlewareRateLimit(policy, ok))
+
+	call := func(h http.HandlerFunc, addr, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = addr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	// anonymous clients are told apart by address
+	call(anon, "10.0.0.1:1", "")
+	rec := call(anon, "10.0.0.1:2", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second request = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+	if got := rec.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+		t.Errorf("RateLimit-Policy = %q, want 2;w=60", got)
+	}
+	rec = call(anon, "10.0.0.1:3", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("third request = %d, Retry-After %q; want 429, 30",
+			rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := call(anon, "10.0.0.2:1", ""); rec.Code != http.StatusOK {
+		t.Errorf("request from another address = %d, want 200", rec.Code)
+	}
+
+	// authenticated ones by user, wherever they are
+	call(authed, "10.0.0.3:1", walt.AccessToken)
+	call(authed, "10.0.0.4:1", walt.AccessToken)
+	if rec := call(authed, "10.0.0.5:1", walt.AccessToken); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("third request from the user = %d, want 429", rec.Code)
+	}
+
+	// and Chirpy Red users get more
+	if err := cfg.userDB.UpgradeUser(walt.Id); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < policy.RedLimit; i++ {
+		if rec := call(authed, "10.0.0.3:1", walt.AccessToken); rec.Code != http.StatusOK {
+			t.Fatalf("request %d as a Red user = %d, want 200", i+1, rec.Code)
+		}
+	}
+	if rec := call(authed, "10.0.0.3:1", walt.AccessToken); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("request past the Red limit = %d, want 429", rec.Code)
+	}
+}
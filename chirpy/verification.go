@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+	chirpymail "github.com/jsMRSoL/avian-din/internal/mail"
+)
+
+// validEmail reports whether email is a bare address such as
+// walt@breakingbad.com, without a display name or angle brackets
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// sendVerification mails userID a token confirming they can read mail
+// sent to email
+func (cfg *apiConfig) sendVerification(userID int, email string) (database.User, error) {
+	token, err := makeToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := cfg.userDB.CreateEmailVerification(
+		userID,
+		email,
+		token,
+		time.Now().Add(verifyTokenTTL),
+	)
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, cfg.mailVerification(email, token)
+}
+
+// mailVerification sends the token confirming email to it
+func (cfg *apiConfig) mailVerification(email, token string) error {
+	return cfg.mailer.Send(chirpymail.Message{
+		To:      email,
+		Subject: "Confirm your email for Chirpy",
+		Body: fmt.Sprintf(
+			"To confirm this is your address, send this token to "+
+				"/api/users/verify within %s:\n\n%s\n\n"+
+				"If you don't have a Chirpy account, you can ignore this email.\n",
+			verifyTokenTTL,
+			token,
+		),
+	})
+}
+
+// verificationPolicy holds the actions that need a verified email. The
+// actions are the names in verifiableActions.
+type verificationPolicy map[string]bool
+
+// verifiableActions are the actions a verificationPolicy can restrict
+var verifiableActions = []string{"post", "delete"}
+
+// parseVerificationPolicy reads a comma separated list of actions, such
+// as "post,delete". "none" restricts nothing.
+func parseVerificationPolicy(s string) (verificationPolicy, error) {
+	policy := verificationPolicy{}
+	if s == "none" {
+		return policy, nil
+	}
+	for _, action := range strings.Split(s, ",") {
+		action = strings.TrimSpace(action)
+		if !slices.Contains(verifiableActions, action) {
+			return nil, fmt.Errorf(
+				"unknown action %q; want none or some of %s",
+				action,
+				strings.Join(verifiableActions, ", "),
+			)
+		}
+		policy[action] = true
+	}
+	return policy, nil
+}
+
+// middlewareVerified refuses action to users without a verified email,
+// if the policy restricts it. It goes inside middlewareAuth.
+func (cfg *apiConfig) middlewareVerified(
+	action string,
+	next http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.requireVerified[action] {
+			next(w, r)
+			return
+		}
+		user, err := cfg.userDB.GetUser(requestPrincipal(r).UserID)
+		if err != nil {
+			log.Printf("Could not look up user: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		if !user.Verified {
+			respondWithError(w, http.StatusForbidden, "Email address not verified")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jsMRSoL/avian-din/internal/database"
+	"github.com/jsMRSoL/avian-din/internal/mail"
+)
+
+func Test_validEmail(t *testing.T) {
+	tests := map[string]bool{
+		"walt@breakingbad.com":            true,
+		"walt.white+cook@breakingbad.com": true,
+		"":                                false,
+		"walt":                            false,
+		"walt@":                           false,
+		"Walt <walt@breakingbad.com>":     false,
+		"<walt@breakingbad.com>":          false,
+		"walt@breakingbad.com, jesse@breakingbad.com": false,
+	}
+	for email, want := range tests {
+		if got := validEmail(email); got != want {
+			t.Errorf("validEmail(%q) = %v, want %v", email, got, want)
+		}
+	}
+}
+
+func Test_parseVerificationPolicy(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    verificationPolicy
+		wantErr bool
+	}{
+		{s: "none", want: verificationPolicy{}},
+		{s: "post", want: verificationPolicy{"post": true}},
+		{s: "post, delete", want: verificationPolicy{"post": true, "delete": true}},
+		{s: "", wantErr: true},
+		{s: "post,login", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseVerificationPolicy(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseVerificationPolicy(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseVerificationPolicy(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func Test_emailVerification(t *testing.T) {
+	cfg := setupAuthConfig(t)
+	var outbox bytes.Buffer
+	cfg.mailer = mail.NewLogMailer(&outbox)
+	cfg.requireVerified = verificationPolicy{"post": true}
+
+	tokenRe := regexp.MustCompile(`(?m)^([0-9a-f]{64})\r$`)
+	// lastToken returns the token in the last mail sent
+	lastToken := func() string {
+		t.Helper()
+		matches := tokenRe.FindAllStringSubmatch(outbox.String(), -1)
+		if len(matches) == 0 {
+			t.Fatalf("no token in mail:\n%s", outbox.String())
+		}
+		return matches[len(matches)-1][1]
+	}
+	do := func(handler http.HandlerFunc, method, body, token string) (int, database.User) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/users", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+			handler = cfg.middlewareAuth(handler)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		user := database.User{}
+		json.Unmarshal(rec.Body.Bytes(), &user)
+		return rec.Code, user
+	}
+	posted := false
+	postChirp := cfg.middlewareVerified("post", func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	})
+
+	if code, _ := do(cfg.addUser, "POST", `{"email":"not an email","password":"abcdef"}`, ""); code != http.StatusBadRequest {
+		t.Errorf("addUser() invalid email = %d, want 400", code)
+	}
+	if code, _ := do(cfg.addUser, "POST", `{"email":"walt@breakingbad.com","password":"abcdef"}`, ""); code != http.StatusConflict {
+		t.Errorf("addUser() registered email = %d, want 409", code)
+	}
+	code, jesse := do(cfg.addUser, "POST", `{"email":"jesse@breakingbad.com","password":"abcdef"}`, "")
+	if code != http.StatusCreated || jesse.Verified {
+		t.Fatalf("addUser() = %d, %+v; want an unverified user", code, jesse)
+	}
+	if !strings.Contains(outbox.String(), "To: jesse@breakingbad.com") {
+		t.Fatalf("addUser() sent no verification:\n%s", outbox.String())
+	}
+	signupToken := lastToken()
+
+	session := loginAs(t, cfg, "jesse@breakingbad.com", "abcdef", "phone")
+	if code, _ := do(postChirp, "POST", `{"body":"yo"}`, session.AccessToken); code != http.StatusForbidden || posted {
+		t.Errorf("posting unverified = %d, want 403", code)
+	}
+	if code, user := do(cfg.verifyEmail, "POST", `{"token":"`+signupToken+`"}`, ""); code != http.StatusOK || !user.Verified {
+		t.Fatalf("verifyEmail() = %d, %+v; want verified", code, user)
+	}
+	if code, _ := do(postChirp, "POST", `{"body":"yo"}`, session.AccessToken); code != http.StatusOK || !posted {
+		t.Errorf("posting verified = %d, want 200", code)
+	}
+	if code, _ := do(cfg.resendVerification, "POST", "", session.AccessToken); code != http.StatusBadRequest {
+		t.Errorf("resendVerification() when verified = %d, want 400", code)
+	}
+
+	// changing address leaves the old one in use until the new one is confirmed
+	code, _ = do(cfg.updateUser, "PUT", `{"email":"walt@breakingbad.com","current_password":"abcdef"}`, session.AccessToken)
+	if code != http.StatusConflict {
+		t.Errorf("updateUser() to a registered email = %d, want 409", code)
+	}
+	code, user := do(cfg.updateUser, "PUT", `{"email":"capn@breakingbad.com","current_password":"abcdef"}`, session.AccessToken)
+	if code != http.StatusOK || user.Email != "jesse@breakingbad.com" || user.PendingEmail != "capn@breakingbad.com" {
+		t.Fatalf("updateUser() = %d, %+v; want the new email pending", code, user)
+	}
+	if !strings.Contains(outbox.String(), "To: capn@breakingbad.com") {
+		t.Fatalf("updateUser() sent no verification to the new address")
+	}
+	loginAs(t, cfg, "jesse@breakingbad.com", "abcdef", "laptop")
+
+	if code, _ := do(cfg.resendVerification, "POST", "", session.AccessToken); code != http.StatusAccepted {
+		t.Errorf("resendVerification() = %d, want 202", code)
+	}
+	if code, user := do(cfg.verifyEmail, "POST", `{"token":"`+lastToken()+`"}`, ""); code != http.StatusOK || user.Email != "capn@breakingbad.com" {
+		t.Fatalf("verifyEmail() = %d, %+v; want the new email in use", code, user)
+	}
+	loginAs(t, cfg, "capn@breakingbad.com", "abcdef", "laptop")
+	if code, _ := do(cfg.verifyEmail, "POST", `{"token":"`+signupToken+`"}`, ""); code != http.StatusBadRequest {
+		t.Errorf("verifyEmail() with a used token = %d, want 400", code)
+	}
+}